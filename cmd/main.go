@@ -2,64 +2,128 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"l0/internal"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/segmentio/kafka-go"
 )
 
+const maxValidateBodySize = 1 << 20
+
 func main() {
-	log.Println("l0 service start")
+	// схема печатается без конфигурации и подключений: make schema
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		os.Exit(printSchema())
+	}
+
 	// err := godotenv.Load()
 	// if err != nil {
 	// 	log.Printf("ошибка загрузки секретов из .env: %v", err)
 	// }
 
-	connstring := os.Getenv("PG_CONNSTRING") + "?sslmode=" + os.Getenv("PG_SSLMODE")
-	db, err := internal.NewDB(connstring)
+	cfg, args, err := internal.LoadConfig(os.Args[1:])
+	if err != nil {
+		fatal("ошибка загрузки конфигурации", err)
+	}
+
+	logger, err := internal.NewLogger(os.Stdout, cfg.Log)
+	if err != nil {
+		fatal("ошибка настройки логирования", err)
+	}
+	// через логгер по умолчанию идут и записи стандартного log из зависимостей
+	slog.SetDefault(logger)
+	gin.SetMode(gin.ReleaseMode)
+
+	db, err := internal.NewDB(cfg.Postgres)
+	if err != nil {
+		fatal("ошибка подключения к бд", err)
+	}
+
+	// для migrate проверена только конфигурация бд и логирования, до запуска сервиса дело не доходит
+	if len(args) > 0 && args[0] == "migrate" {
+		code := runMigrate(context.Background(), db, args[1:])
+		db.Close()
+		os.Exit(code)
+	}
+
+	slog.Info("l0 service start", slog.String("config", cfg.String()))
+
+	err = migrateOnStart(context.Background(), db, cfg.Postgres.MigrateOnStart)
+	if err != nil {
+		fatal("ошибка миграции схемы бд", err)
+	}
+
+	shutdownTracing, err := internal.InitTracing(context.Background(), cfg.Tracing)
 	if err != nil {
-		log.Println(connstring)
-		log.Fatal("ошибка подключения к бд: %w. ", err)
+		fatal("ошибка настройки трассировки", err)
 	}
 
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:        []string{os.Getenv("KAFKA_CONN")},
-		Topic:          os.Getenv("KAFKA_TOPICNAME"),
-		GroupID:        os.Getenv("KAFKA_GROUPID"),
-		MinBytes:       10,
-		MaxBytes:       10e6,
-		MaxWait:        1 * time.Second,
+		Brokers:        cfg.Kafka.Brokers,
+		Topic:          cfg.Kafka.Topic,
+		GroupID:        cfg.Kafka.GroupID,
+		MinBytes:       cfg.Kafka.MinBytes,
+		MaxBytes:       cfg.Kafka.MaxBytes,
+		MaxWait:        cfg.Kafka.MaxWait,
 		CommitInterval: 0,
 	})
-	defer reader.Close()
 
-	log.Println("===")
-	log.Println(os.Getenv("KAFKA_CONN"))
-	log.Println(os.Getenv("KAFKA_TOPICNAME"))
-	log.Println(os.Getenv("KAFKA_GROUPID"))
-	log.Println("===")
+	dlq := internal.NewDeadLetterQueue(cfg.Kafka.Brokers, cfg.Kafka.DLQTopic)
 
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	messages := make(chan kafka.Message, 50)
-	defer close(messages)
-	cache := make(map[string]internal.Order)
+	// контекст обработки отменяется отдельно, чтобы воркер мог дообработать сообщения после сигнала
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
 
-	err = internal.FillCache(ctx, db, cache)
-	if err != nil {
-		log.Println("ошибка заполнения кеша при старте: %w", err)
+	validator := internal.NewValidator(cfg.Consistency)
+	validator.StrictSchema = cfg.Schema.Strict
+	if loader := internal.NewRulesLoader(cfg.Rules, db); loader != nil {
+		err = internal.ReloadRules(ctx, validator, loader)
+		if err != nil {
+			fatal("ошибка загрузки справочников валидации", err)
+		}
+		go internal.WatchRules(ctx, validator, loader, cfg.Rules.ReloadInterval)
 	}
 
-	go internal.SubscribeOnTopic(ctx, reader, messages)
-	go internal.Worker(ctx, messages, db, cache, reader)
+	messages := make(chan kafka.Message, cfg.Kafka.MessagesBuffer)
+	repo := internal.NewPostgresRepository(db)
+	cache := internal.NewLRUCache(cfg.Cache.MaxSize, cfg.Cache.TTL)
 
-	router := gin.Default()
+	progress := internal.NewWorkerProgress()
+	warmup := internal.NewWarmupProgress()
+	health := &internal.Health{
+		DB:             db,
+		Brokers:        cfg.Kafka.Brokers,
+		Cache:          cache,
+		Progress:       progress,
+		Warmup:         warmup,
+		StallThreshold: cfg.Health.StallThreshold,
+		CheckTimeout:   cfg.Health.CheckTimeout,
+	}
+
+	internal.RegisterMetrics(db, cache, reader)
+
+	router := gin.New()
+	router.Use(internal.HTTPTracing(cfg.Tracing.ServiceName))
+	router.Use(internal.RequestLogger(), gin.Recovery())
+	router.Use(internal.HTTPMetrics())
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(200)
@@ -67,12 +131,117 @@ func main() {
 		}
 		c.Next()
 	})
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/healthz", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"status": internal.HealthStatusOK,
+		})
+	})
+	router.GET("/readyz", func(c *gin.Context) {
+		report := health.Ready(c.Request.Context())
+		if report.Status != internal.HealthStatusOK {
+			c.JSON(503, report)
+			return
+		}
+		c.JSON(200, report)
+	})
+	router.GET("/orders", func(c *gin.Context) {
+		search, err := internal.ParseOrderSearch(c.Request.URL.Query())
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error":   "invalid search parameters",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		result, err := internal.SearchOrders(c.Request.Context(), repo, search)
+		if errors.Is(err, internal.ErrInvalidSearch) {
+			c.JSON(400, gin.H{
+				"error":   "invalid search parameters",
+				"details": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			internal.Logger(c.Request.Context()).Error("ошибка поиска заказов", slog.Any(internal.LogKeyError, err))
+			c.JSON(500, gin.H{
+				"error": "internal error",
+			})
+			return
+		}
+
+		c.JSON(200, result)
+	})
+	ordersBy := func(c *gin.Context, index internal.OrderIndex, value string) ([]internal.Order, bool) {
+		orders, err := internal.GetOrdersBy(c.Request.Context(), repo, cache, index, value)
+		if err != nil {
+			internal.Logger(c.Request.Context()).Error("ошибка получения заказов",
+				slog.String(string(index), value),
+				slog.Any(internal.LogKeyError, err),
+			)
+			c.JSON(500, gin.H{
+				"error": "internal error",
+			})
+			return nil, false
+		}
+		return orders, true
+	}
+	router.GET("/orders/by-track/:track_number", func(c *gin.Context) {
+		orders, ok := ordersBy(c, internal.OrderIndexTrackNumber, c.Param("track_number"))
+		if !ok {
+			return
+		}
+		if len(orders) == 0 {
+			c.JSON(404, gin.H{
+				"error": "order not found",
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"orders": orders,
+		})
+	})
+	router.GET("/orders/by-transaction/:transaction", func(c *gin.Context) {
+		orders, ok := ordersBy(c, internal.OrderIndexTransaction, c.Param("transaction"))
+		if !ok {
+			return
+		}
+		if len(orders) == 0 {
+			c.JSON(404, gin.H{
+				"error": "order not found",
+			})
+			return
+		}
+
+		// транзакция принадлежит одному заказу; если их несколько, отдается самый новый
+		c.JSON(200, gin.H{
+			"order": orders[0],
+		})
+	})
+	router.GET("/customers/:customer_id/orders", func(c *gin.Context) {
+		orders, ok := ordersBy(c, internal.OrderIndexCustomerID, c.Param("customer_id"))
+		if !ok {
+			return
+		}
+		if orders == nil {
+			orders = []internal.Order{}
+		}
+
+		c.JSON(200, gin.H{
+			"orders": orders,
+		})
+	})
 	router.GET("/order/:ouid", func(c *gin.Context) {
 		orderUID := c.Param("ouid")
 
-		order, err := internal.GetOrderByID(db, orderUID, cache)
+		order, err := internal.GetOrderByID(c.Request.Context(), repo, orderUID, cache)
 		if err != nil {
-			log.Printf("заказ не найден: %v. ", err)
+			internal.Logger(c.Request.Context()).Warn("заказ не найден",
+				slog.String(internal.LogKeyOrderUID, orderUID),
+				slog.Any(internal.LogKeyError, err),
+			)
 			c.JSON(404, gin.H{
 				"error": "order not found",
 			})
@@ -83,6 +252,151 @@ func main() {
 			"order": order,
 		})
 	})
+	router.POST("/order/validate", func(c *gin.Context) {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxValidateBodySize))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": "request body too large or unreadable",
+			})
+			return
+		}
+
+		result := validator.ValidateJSON(body)
+		status := 200
+		if !result.Valid() {
+			status = 422
+		}
+		violations := result.Violations
+		if violations == nil {
+			violations = []internal.Violation{}
+		}
+		c.JSON(status, gin.H{
+			"valid":      result.Valid(),
+			"violations": violations,
+			"warnings":   result.Warnings,
+		})
+	})
+	router.GET("/order/schema", func(c *gin.Context) {
+		c.JSON(200, internal.OrderSchema())
+	})
+	router.GET("/order/:ouid/history", func(c *gin.Context) {
+		orderUID := c.Param("ouid")
+
+		history, err := internal.GetOrderHistory(c.Request.Context(), repo, orderUID)
+		if err != nil {
+			internal.Logger(c.Request.Context()).Error("ошибка получения истории заказа",
+				slog.String(internal.LogKeyOrderUID, orderUID),
+				slog.Any(internal.LogKeyError, err),
+			)
+			c.JSON(500, gin.H{
+				"error": "internal error",
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"history": history,
+		})
+	})
+
+	server := &http.Server{
+		Addr:    ":" + strconv.Itoa(cfg.HTTP.Port),
+		Handler: router,
+	}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("ошибка http сервера", slog.Any(internal.LogKeyError, err))
+			stop()
+		}
+	}()
+
+	// прогрев идет в фоне: сервис уже отвечает на запросы, а промахи кеша читаются из бд
+	go func() {
+		err := internal.FillCache(ctx, repo, cache, cfg.Cache.Warmup(), warmup)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("ошибка заполнения кеша при старте", slog.Any(internal.LogKeyError, err))
+		}
+	}()
+
+	go internal.SubscribeOnTopic(ctx, reader, messages)
+
+	pool := &internal.WorkerPool{
+		Size:     cfg.Worker.PoolSize,
+		Dispatch: cfg.Worker.Dispatch,
+		Repo:     repo,
+		Cache:    cache,
+		Reader:   reader,
+		DLQ:      dlq,
+		Retry:    cfg.Retry.Policy(),
+
+		Validator: validator,
+
+		BatchSize:   cfg.Worker.BatchSize,
+		BatchWindow: cfg.Worker.BatchWindow,
+
+		Progress: progress,
+	}
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		pool.Run(workCtx, messages)
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("l0 service stopping")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancelShutdown()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Error("ошибка остановки http сервера", slog.Any(internal.LogKeyError, err))
+	}
+
+	select {
+	case <-workerDone:
+	case <-shutdownCtx.Done():
+		slog.Warn("воркер не успел дообработать сообщения, обработка прервана")
+		cancelWork()
+		<-workerDone
+	}
+
+	err = reader.Close()
+	if err != nil {
+		slog.Error("ошибка закрытия kafka reader", slog.Any(internal.LogKeyError, err))
+	}
+	err = dlq.Close()
+	if err != nil {
+		slog.Error("ошибка закрытия dead-letter writer", slog.Any(internal.LogKeyError, err))
+	}
+	err = db.Close()
+	if err != nil {
+		slog.Error("ошибка закрытия подключения к бд", slog.Any(internal.LogKeyError, err))
+	}
+
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelTracing()
+	err = shutdownTracing(tracingCtx)
+	if err != nil {
+		slog.Error("ошибка отправки spans при остановке", slog.Any(internal.LogKeyError, err))
+	}
+
+	slog.Info("l0 service stopped")
+}
+
+func printSchema() int {
+	data, err := json.MarshalIndent(internal.OrderSchema(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
 
-	router.Run(":" + os.Getenv("HTTP_PORT"))
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any(internal.LogKeyError, err))
+	os.Exit(1)
 }
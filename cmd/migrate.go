@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"l0/internal"
+	"l0/migrations"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = "использование: l0 migrate up|down|status|baseline <версия>"
+
+// runMigrate выполняет подкоманду migrate и возвращает код завершения процесса
+func runMigrate(ctx context.Context, db *sql.DB, args []string) int {
+	migrator, err := internal.NewMigrator(db, migrations.FS)
+	if err != nil {
+		slog.Error("ошибка загрузки миграций", slog.Any(internal.LogKeyError, err))
+		return 1
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			slog.Info("применена миграция", migrationAttrs(m)...)
+		}
+		if err != nil {
+			slog.Error("ошибка применения миграций", slog.Any(internal.LogKeyError, err))
+			return 1
+		}
+		if len(applied) == 0 {
+			slog.Info("непримененных миграций нет")
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			slog.Error("ошибка отката миграции", slog.Any(internal.LogKeyError, err))
+			return 1
+		}
+		slog.Info("откачена миграция", migrationAttrs(reverted)...)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			slog.Error("ошибка получения статуса миграций", slog.Any(internal.LogKeyError, err))
+			return 1
+		}
+		for _, s := range statuses {
+			state := "не применена"
+			switch {
+			case s.Unknown:
+				state = "применена " + s.AppliedAt.Format("2006-01-02 15:04:05") + ", неизвестна сервису"
+			case s.Applied:
+				state = "применена " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%03d_%v\t%v\n", s.Version, s.Name, state)
+		}
+	case "baseline":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			slog.Error("некорректная версия", slog.Any(internal.LogKeyError, err))
+			return 2
+		}
+		err = migrator.Baseline(ctx, version)
+		if err != nil {
+			slog.Error("ошибка отметки миграций", slog.Any(internal.LogKeyError, err))
+			return 1
+		}
+		slog.Info("миграции отмечены примененными", slog.Int("version", version))
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
+
+// migrateOnStart применяет миграции при старте сервиса, если это разрешено, и не дает запуститься,
+// если схема бд новее сервиса или отстает от него, а применять миграции запрещено
+func migrateOnStart(ctx context.Context, db *sql.DB, apply bool) error {
+	migrator, err := internal.NewMigrator(db, migrations.FS)
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки миграций: %w", err)
+	}
+
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// запросы сервиса рассчитаны на последнюю схему: со старой он упал бы на первом же сообщении
+	if !apply {
+		for _, m := range pending {
+			slog.Error("миграция не применена, выполните l0 migrate up", migrationAttrs(m)...)
+		}
+		return fmt.Errorf("схема бд отстает от сервиса на %d миграций, а MIGRATE_ON_START=false", len(pending))
+	}
+
+	applied, err := migrator.Up(ctx)
+	for _, m := range applied {
+		slog.Info("применена миграция", migrationAttrs(m)...)
+	}
+	return err
+}
+
+func migrationAttrs(m internal.Migration) []any {
+	return []any{slog.Int("version", m.Version), slog.String("migration", m.Name)}
+}
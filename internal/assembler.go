@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// orderRowsQuery возвращает одну строку на товар заказа (или одну строку для заказа без товаров);
+// запросы дописывают к нему WHERE и ORDER BY, а orderAssembler собирает строки обратно в заказы
+const orderRowsQuery = `
+	SELECT
+		o.order_uid,
+		o.track_number,
+		o.entry,
+		o.locale,
+		o.internal_signature,
+		o.customer_id,
+		o.delivery_service,
+		o.shardkey,
+		o.sm_id,
+		o.date_created,
+		o.oof_shard,
+		o.event_time,
+		d.name AS delivery_name,
+		d.phone AS delivery_phone,
+		d.zip AS delivery_zip,
+		d.city AS delivery_city,
+		d.address AS delivery_address,
+		d.region AS delivery_region,
+		d.email AS delivery_email,
+		p.transaction,
+		p.request_id,
+		p.currency,
+		p.provider,
+		p.amount,
+		p.payment_dt,
+		p.bank,
+		p.delivery_cost,
+		p.goods_total,
+		p.custom_fee,
+		oi.chrt_id,
+		i.name AS item_name,
+		i.size AS item_size,
+		i.nm_id,
+		i.brand,
+		oi.track_number AS item_track_number,
+		oi.price,
+		oi.sale,
+		oi.total_price AS item_total_price,
+		oi.rid,
+		oi.status
+	FROM orders o
+	LEFT JOIN delivery d ON o.order_uid = d.order_uid
+	LEFT JOIN payment p ON o.order_uid = p.order_uid
+	LEFT JOIN order_items oi ON o.order_uid = oi.order_uid
+	LEFT JOIN items i ON oi.chrt_id = i.chrt_id
+`
+
+// orderItemsOrder - порядок товаров внутри заказа: как в сообщении, для строк, сохраненных
+// до появления position, - по chrt_id и rid
+const orderItemsOrder = `oi.position, oi.chrt_id, oi.rid`
+
+// orderAssembler группирует строки orderRowsQuery по order_uid. Заказы возвращаются в порядке
+// первой строки каждого заказа, товары - в порядке строк, поэтому порядок целиком задает ORDER BY запроса
+type orderAssembler struct {
+	orders []*Order
+	byUID  map[string]*Order
+}
+
+func newOrderAssembler() *orderAssembler {
+	return &orderAssembler{byUID: make(map[string]*Order)}
+}
+
+func (a *orderAssembler) add(r dbRow) {
+	order, ok := a.byUID[r.OrderUID]
+	if !ok {
+		order = r.order()
+		a.byUID[r.OrderUID] = order
+		a.orders = append(a.orders, order)
+	}
+
+	// строка без товара появляется только у заказа без товаров
+	if r.ChrtID.Valid {
+		order.Items = append(order.Items, r.item())
+	}
+}
+
+func (a *orderAssembler) result() []Order {
+	result := make([]Order, 0, len(a.orders))
+	for _, order := range a.orders {
+		order.setCurrency()
+		result = append(result, *order)
+	}
+	return result
+}
+
+// queryOrders выполняет запрос, построенный на orderRowsQuery, и собирает заказы из его строк
+func queryOrders(ctx context.Context, db *sql.DB, query string, args ...any) ([]Order, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выполнения запроса: %w. ", err)
+	}
+	defer rows.Close()
+
+	assembler := newOrderAssembler()
+	for rows.Next() {
+		var r dbRow
+		err := rows.Scan(
+			&r.OrderUID, &r.TrackNumber, &r.Entry, &r.Locale, &r.InternalSignature,
+			&r.CustomerID, &r.DeliveryService, &r.Shardkey, &r.SmID, &r.DateCreated, &r.OofShard, &r.EventTime,
+			&r.DeliveryName, &r.DeliveryPhone, &r.DeliveryZip, &r.DeliveryCity,
+			&r.DeliveryAddress, &r.DeliveryRegion, &r.DeliveryEmail,
+			&r.Transaction, &r.RequestID, &r.Currency, &r.Provider,
+			&r.Amount, &r.PaymentDt, &r.Bank, &r.DeliveryCost, &r.GoodsTotal, &r.CustomFee,
+			&r.ChrtID, &r.ItemName, &r.ItemSize, &r.NmID, &r.Brand,
+			&r.ItemTrackNumber, &r.Price, &r.Sale, &r.ItemTotalPrice, &r.Rid, &r.Status,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования строки: %w. ", err)
+		}
+		assembler.add(r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка при итерации по строкам: %w", err)
+	}
+
+	return assembler.result(), nil
+}
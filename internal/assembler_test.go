@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var assemblerDate = time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+
+// orderRow - строка orderRowsQuery заказа uid с доставкой и платежом в RUB
+func orderRow(uid string) dbRow {
+	return dbRow{
+		OrderUID:        uid,
+		TrackNumber:     "WBILMTESTTRACK",
+		Entry:           "WBIL",
+		Locale:          "en",
+		CustomerID:      "test",
+		DeliveryService: "meest",
+		Shardkey:        "9",
+		SmID:            99,
+		DateCreated:     assemblerDate,
+		OofShard:        "1",
+		EventTime:       sql.NullTime{Time: assemblerDate, Valid: true},
+		DeliveryName:    sql.NullString{String: "Test Testov", Valid: true},
+		DeliveryPhone:   sql.NullString{String: "+9720000000", Valid: true},
+		DeliveryZip:     sql.NullString{String: "2639809", Valid: true},
+		DeliveryCity:    sql.NullString{String: "Kiryat Mozkin", Valid: true},
+		DeliveryAddress: sql.NullString{String: "Ploshad Mira 15", Valid: true},
+		DeliveryRegion:  sql.NullString{String: "Kraiot", Valid: true},
+		DeliveryEmail:   sql.NullString{String: "test@gmail.com", Valid: true},
+		Transaction:     sql.NullString{String: uid, Valid: true},
+		Currency:        sql.NullString{String: "RUB", Valid: true},
+		Provider:        sql.NullString{String: "wbpay", Valid: true},
+		Amount:          Money{Minor: 181710},
+		PaymentDt:       sql.NullTime{Time: time.Unix(1637907727, 0), Valid: true},
+		Bank:            sql.NullString{String: "alpha", Valid: true},
+		DeliveryCost:    Money{Minor: 150000},
+		GoodsTotal:      Money{Minor: 31710},
+		CustomFee:       Money{Minor: 0},
+	}
+}
+
+// withItem дополняет строку заказа товаром chrtID
+func withItem(r dbRow, chrtID int64, rid string) dbRow {
+	r.ChrtID = sql.NullInt64{Int64: chrtID, Valid: true}
+	r.ItemName = sql.NullString{String: "Mascaras", Valid: true}
+	r.ItemSize = sql.NullString{String: "0", Valid: true}
+	r.NmID = sql.NullInt64{Int64: 2389212, Valid: true}
+	r.Brand = sql.NullString{String: "Vivienne Sabo", Valid: true}
+	r.ItemTrackNumber = sql.NullString{String: "WBILMTESTTRACK", Valid: true}
+	r.Price = Money{Minor: 45300}
+	r.Sale = sql.NullInt64{Int64: 30, Valid: true}
+	r.ItemTotalPrice = Money{Minor: 31710}
+	r.Rid = sql.NullString{String: rid, Valid: true}
+	r.Status = sql.NullInt64{Int64: 202, Valid: true}
+	return r
+}
+
+// withoutPayment и withoutDelivery - строки заказа, для которого LEFT JOIN не нашел платеж или доставку
+func withoutPayment(r dbRow) dbRow {
+	r.Transaction = sql.NullString{}
+	r.RequestID = sql.NullString{}
+	r.Currency = sql.NullString{}
+	r.Provider = sql.NullString{}
+	r.Amount = Money{}
+	r.PaymentDt = sql.NullTime{}
+	r.Bank = sql.NullString{}
+	r.DeliveryCost = Money{}
+	r.GoodsTotal = Money{}
+	r.CustomFee = Money{}
+	return r
+}
+
+func withoutDelivery(r dbRow) dbRow {
+	r.DeliveryName = sql.NullString{}
+	r.DeliveryPhone = sql.NullString{}
+	r.DeliveryZip = sql.NullString{}
+	r.DeliveryCity = sql.NullString{}
+	r.DeliveryAddress = sql.NullString{}
+	r.DeliveryRegion = sql.NullString{}
+	r.DeliveryEmail = sql.NullString{}
+	return r
+}
+
+func expectedOrder(uid string, items ...Item) Order {
+	if items == nil {
+		items = []Item{}
+	}
+	return Order{
+		OrderUID:    uid,
+		TrackNumber: "WBILMTESTTRACK",
+		Entry:       "WBIL",
+		Delivery: Delivery{
+			Name:    "Test Testov",
+			Phone:   "+9720000000",
+			Zip:     "2639809",
+			City:    "Kiryat Mozkin",
+			Address: "Ploshad Mira 15",
+			Region:  "Kraiot",
+			Email:   "test@gmail.com",
+		},
+		Payment: Payment{
+			Transaction:  uid,
+			Currency:     "RUB",
+			Provider:     "wbpay",
+			Amount:       NewMoney(181710, "RUB"),
+			PaymentDt:    1637907727,
+			Bank:         "alpha",
+			DeliveryCost: NewMoney(150000, "RUB"),
+			GoodsTotal:   NewMoney(31710, "RUB"),
+			CustomFee:    NewMoney(0, "RUB"),
+		},
+		Items:           items,
+		Locale:          "en",
+		CustomerID:      "test",
+		DeliveryService: "meest",
+		Shardkey:        "9",
+		SmID:            99,
+		DateCreated:     "2021-11-26T06:22:19Z",
+		OofShard:        "1",
+		EventTime:       assemblerDate,
+	}
+}
+
+func expectedItem(chrtID int, rid string) Item {
+	return Item{
+		ChrtID:      chrtID,
+		TrackNumber: "WBILMTESTTRACK",
+		Price:       NewMoney(45300, "RUB"),
+		Rid:         rid,
+		Name:        "Mascaras",
+		Sale:        30,
+		Size:        "0",
+		TotalPrice:  NewMoney(31710, "RUB"),
+		NmID:        2389212,
+		Brand:       "Vivienne Sabo",
+		Status:      202,
+	}
+}
+
+func TestOrderAssembler(t *testing.T) {
+	noPayment := expectedOrder("a")
+	noPayment.Payment = Payment{}
+	noDelivery := expectedOrder("a", expectedItem(1, "r1"))
+	noDelivery.Delivery = Delivery{}
+
+	tests := []struct {
+		name string
+		rows []dbRow
+		want []Order
+	}{
+		{
+			name: "нет строк",
+			want: []Order{},
+		},
+		{
+			name: "заказ без товаров",
+			rows: []dbRow{orderRow("a")},
+			want: []Order{expectedOrder("a")},
+		},
+		{
+			name: "заказ с несколькими товарами",
+			rows: []dbRow{
+				withItem(orderRow("a"), 1, "r1"),
+				withItem(orderRow("a"), 2, "r2"),
+				withItem(orderRow("a"), 3, "r3"),
+			},
+			want: []Order{expectedOrder("a", expectedItem(1, "r1"), expectedItem(2, "r2"), expectedItem(3, "r3"))},
+		},
+		{
+			// порядок по position, chrt_id и rid задает ORDER BY запроса, assembler его не меняет
+			name: "товары в порядке строк",
+			rows: []dbRow{
+				withItem(orderRow("a"), 3, "r3"),
+				withItem(orderRow("a"), 1, "r2"),
+				withItem(orderRow("a"), 1, "r1"),
+			},
+			want: []Order{expectedOrder("a", expectedItem(3, "r3"), expectedItem(1, "r2"), expectedItem(1, "r1"))},
+		},
+		{
+			name: "заказы в порядке первой строки",
+			rows: []dbRow{
+				withItem(orderRow("c"), 1, "r1"),
+				orderRow("a"),
+				withItem(orderRow("b"), 2, "r2"),
+				withItem(orderRow("c"), 3, "r3"),
+				withItem(orderRow("b"), 4, "r4"),
+			},
+			want: []Order{
+				expectedOrder("c", expectedItem(1, "r1"), expectedItem(3, "r3")),
+				expectedOrder("a"),
+				expectedOrder("b", expectedItem(2, "r2"), expectedItem(4, "r4")),
+			},
+		},
+		{
+			name: "NULL колонки платежа",
+			rows: []dbRow{withoutPayment(orderRow("a"))},
+			want: []Order{noPayment},
+		},
+		{
+			name: "NULL колонки доставки",
+			rows: []dbRow{withItem(withoutDelivery(orderRow("a")), 1, "r1")},
+			want: []Order{noDelivery},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assembler := newOrderAssembler()
+			for _, r := range tt.rows {
+				assembler.add(r)
+			}
+
+			got := assembler.result()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("result() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type Cache interface {
+	Get(orderUID string) (Order, bool)
+	// Peek не влияет на порядок вытеснения и статистику попаданий
+	Peek(orderUID string) (Order, bool)
+	Set(orderUID string, order Order)
+	// SetIfNewer не заменяет запись, событие которой новее order; возвращает, записан ли заказ
+	SetIfNewer(orderUID string, order Order) bool
+	// Lookup возвращает заказы кеша со значением вторичного ключа от новых к старым;
+	// complete - в кеше лежат все такие заказы, и бд можно не читать
+	Lookup(index OrderIndex, value string) (orders []Order, complete bool)
+	// SetComplete записывает заказы, прочитанные из бд по значению ключа, как SetIfNewer
+	// и отмечает значение полным, если после записи все они остались в кеше
+	SetComplete(index OrderIndex, value string, orders []Order)
+	Delete(orderUID string)
+	Len() int
+	Stats() CacheStats
+}
+
+type CacheStats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+}
+
+type cacheEntry struct {
+	orderUID  string
+	order     Order
+	expiresAt time.Time
+}
+
+// LRUCache вытесняет давно не использованные заказы при превышении maxSize.
+// maxSize <= 0 отключает ограничение размера, ttl <= 0 отключает истечение записей.
+type LRUCache struct {
+	mu      sync.Mutex
+	maxSize int
+	ttl     time.Duration
+	items   map[string]*list.Element
+	order   *list.List
+	stats   CacheStats
+	indexes map[OrderIndex]*cacheIndex
+}
+
+func NewLRUCache(maxSize int, ttl time.Duration) *LRUCache {
+	indexes := make(map[OrderIndex]*cacheIndex, len(orderIndexes))
+	for _, index := range orderIndexes {
+		indexes[index] = newCacheIndex()
+	}
+
+	return &LRUCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+		indexes: indexes,
+	}
+}
+
+func (c *LRUCache) Get(orderUID string) (Order, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[orderUID]
+	if !ok {
+		c.stats.Misses++
+		return Order{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if c.expired(entry) {
+		c.removeElement(elem)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return Order{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	c.stats.Hits++
+	return entry.order, true
+}
+
+func (c *LRUCache) Peek(orderUID string) (Order, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[orderUID]
+	if !ok {
+		return Order{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if c.expired(entry) {
+		return Order{}, false
+	}
+	return entry.order, true
+}
+
+func (c *LRUCache) Set(orderUID string, order Order) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(orderUID, order)
+}
+
+// SetIfNewer сравнивает и записывает под одной блокировкой, поэтому прогрев кеша
+// не перезапишет версию, которую воркер успел положить после чтения заказа из бд
+func (c *LRUCache) SetIfNewer(orderUID string, order Order) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setIfNewer(orderUID, order)
+}
+
+func (c *LRUCache) setIfNewer(orderUID string, order Order) bool {
+	if elem, ok := c.items[orderUID]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if !c.expired(entry) && entry.order.EventTime.After(order.EventTime) {
+			return false
+		}
+	}
+
+	c.set(orderUID, order)
+	return true
+}
+
+func (c *LRUCache) set(orderUID string, order Order) {
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = time.Now().Add(c.ttl)
+	}
+
+	if elem, ok := c.items[orderUID]; ok {
+		entry := elem.Value.(*cacheEntry)
+		for index, byValue := range c.indexes {
+			if old, updated := index.value(entry.order), index.value(order); old != updated {
+				byValue.remove(old, orderUID)
+				byValue.add(updated, orderUID)
+			}
+		}
+		entry.order = order
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[orderUID] = c.order.PushFront(&cacheEntry{
+		orderUID:  orderUID,
+		order:     order,
+		expiresAt: expiresAt,
+	})
+	for index, byValue := range c.indexes {
+		byValue.add(index.value(order), orderUID)
+	}
+
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *LRUCache) Lookup(index OrderIndex, value string) ([]Order, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	byValue, ok := c.indexes[index]
+	if !ok {
+		return nil, false
+	}
+
+	complete := byValue.complete[value]
+	var orders []Order
+	// removeElement удаляет из того же множества, удаление во время range допустимо
+	for orderUID := range byValue.orders[value] {
+		elem := c.items[orderUID]
+		entry := elem.Value.(*cacheEntry)
+		if c.expired(entry) {
+			c.removeElement(elem)
+			c.stats.Expirations++
+			complete = false
+			continue
+		}
+		c.order.MoveToFront(elem)
+		orders = append(orders, entry.order)
+	}
+
+	if complete {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	sortByDateCreatedDesc(orders)
+	return orders, complete
+}
+
+func (c *LRUCache) SetComplete(index OrderIndex, value string, orders []Order) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	byValue, ok := c.indexes[index]
+	if !ok || value == "" || len(orders) == 0 {
+		return
+	}
+
+	for _, order := range orders {
+		c.setIfNewer(order.OrderUID, order)
+	}
+	// заказы могли вытеснить друг друга, а у более новой версии в кеше значение ключа могло измениться
+	for _, order := range orders {
+		if _, ok := byValue.orders[value][order.OrderUID]; !ok {
+			return
+		}
+	}
+	byValue.complete[value] = true
+}
+
+func (c *LRUCache) Delete(orderUID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[orderUID]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *LRUCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+func (c *LRUCache) Capacity() int {
+	return c.maxSize
+}
+
+func (c *LRUCache) expired(entry *cacheEntry) bool {
+	return !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt)
+}
+
+func (c *LRUCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cacheEntry)
+	delete(c.items, entry.orderUID)
+	for index, byValue := range c.indexes {
+		byValue.remove(index.value(entry.order), entry.orderUID)
+	}
+}
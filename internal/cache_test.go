@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// cacheTTL - ttl кеша в тестах истечения; тест ждет его с запасом
+const cacheTTL = 20 * time.Millisecond
+
+// trackedOrder - заказ uid с трек-номером track, созданный dateHours часов после assemblerDate
+func trackedOrder(uid, track string, dateHours int) Order {
+	created := assemblerDate.Add(time.Duration(dateHours) * time.Hour)
+	order := testOrder(uid, created, assemblerDate)
+	order.TrackNumber = track
+	return order
+}
+
+func orderUIDs(orders []Order) []string {
+	uids := []string{}
+	for _, order := range orders {
+		uids = append(uids, order.OrderUID)
+	}
+	return uids
+}
+
+func TestLRUCache(t *testing.T) {
+	a := trackedOrder("a", "T1", 0)
+	b := trackedOrder("b", "T1", 1)
+	c := trackedOrder("c", "T2", 2)
+
+	tests := []struct {
+		name    string
+		maxSize int
+		ttl     time.Duration
+		run     func(cache *LRUCache)
+		want    []string
+	}{
+		{
+			name: "заказы в кеше",
+			run: func(cache *LRUCache) {
+				cache.Set("a", a)
+				cache.Set("b", b)
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name:    "вытесняется давно не использованный заказ",
+			maxSize: 2,
+			run: func(cache *LRUCache) {
+				cache.Set("a", a)
+				cache.Set("b", b)
+				cache.Get("a")
+				cache.Set("c", c)
+			},
+			want: []string{"a", "c"},
+		},
+		{
+			name:    "Peek не продлевает жизнь заказа",
+			maxSize: 2,
+			run: func(cache *LRUCache) {
+				cache.Set("a", a)
+				cache.Set("b", b)
+				cache.Peek("a")
+				cache.Set("c", c)
+			},
+			want: []string{"b", "c"},
+		},
+		{
+			name: "истекший заказ не возвращается",
+			ttl:  cacheTTL,
+			run: func(cache *LRUCache) {
+				cache.Set("a", a)
+				time.Sleep(2 * cacheTTL)
+				cache.Set("b", b)
+			},
+			want: []string{"b"},
+		},
+		{
+			name: "удаление",
+			run: func(cache *LRUCache) {
+				cache.Set("a", a)
+				cache.Set("b", b)
+				cache.Delete("a")
+			},
+			want: []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewLRUCache(tt.maxSize, tt.ttl)
+			tt.run(cache)
+
+			var got []string
+			for _, uid := range []string{"a", "b", "c"} {
+				if _, ok := cache.Peek(uid); ok {
+					got = append(got, uid)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("в кеше %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLRUCacheSetIfNewer(t *testing.T) {
+	cache := NewLRUCache(0, 0)
+	older := trackedOrder("a", "T1", 0)
+	newer := older
+	newer.EventTime = assemblerDate.Add(time.Hour)
+	newer.Delivery.City = "Moscow"
+
+	if !cache.SetIfNewer("a", newer) {
+		t.Fatalf("SetIfNewer() в пустой кеш = false")
+	}
+	if cache.SetIfNewer("a", older) {
+		t.Errorf("SetIfNewer() более старой версии = true")
+	}
+	if got, _ := cache.Peek("a"); !reflect.DeepEqual(got, newer) {
+		t.Errorf("в кеше %+v, want %+v", got, newer)
+	}
+	// событие того же времени - повторная доставка, она заменяет запись
+	if !cache.SetIfNewer("a", newer) {
+		t.Errorf("SetIfNewer() версии того же времени = false")
+	}
+}
+
+func TestLRUCacheIndexCompleteness(t *testing.T) {
+	a := trackedOrder("a", "T1", 0)
+	b := trackedOrder("b", "T1", 1)
+	c := trackedOrder("c", "T2", 2)
+	movedA := a
+	movedA.TrackNumber = "T2"
+	movedA.EventTime = assemblerDate.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		maxSize int
+		ttl     time.Duration
+		run     func(cache *LRUCache)
+		value   string
+		want    []string
+		// wantComplete - Lookup отвечает без бд
+		wantComplete bool
+	}{
+		{
+			name:  "значение без отметки неполное",
+			run:   func(cache *LRUCache) { cache.Set("a", a) },
+			value: "T1",
+			want:  []string{"a"},
+		},
+		{
+			name:         "полное значение после SetComplete",
+			run:          func(cache *LRUCache) { cache.SetComplete(OrderIndexTrackNumber, "T1", []Order{b, a}) },
+			value:        "T1",
+			want:         []string{"b", "a"},
+			wantComplete: true,
+		},
+		{
+			name: "новый заказ значения остается полным",
+			run: func(cache *LRUCache) {
+				cache.SetComplete(OrderIndexTrackNumber, "T1", []Order{a})
+				cache.SetIfNewer("b", b)
+			},
+			value:        "T1",
+			want:         []string{"b", "a"},
+			wantComplete: true,
+		},
+		{
+			name: "обновление заказа, изменившее значение ключа",
+			run: func(cache *LRUCache) {
+				cache.SetComplete(OrderIndexTrackNumber, "T1", []Order{b, a})
+				cache.SetIfNewer("a", movedA)
+			},
+			value: "T1",
+			want:  []string{"b"},
+		},
+		{
+			name: "обновленный заказ появляется в новом значении",
+			run: func(cache *LRUCache) {
+				cache.SetComplete(OrderIndexTrackNumber, "T2", []Order{c})
+				cache.SetIfNewer("a", a)
+				cache.SetIfNewer("a", movedA)
+			},
+			value:        "T2",
+			want:         []string{"c", "a"},
+			wantComplete: true,
+		},
+		{
+			name:    "вытеснение заказа значения",
+			maxSize: 2,
+			run: func(cache *LRUCache) {
+				// b записан первым и вытесняется
+				cache.SetComplete(OrderIndexTrackNumber, "T1", []Order{b, a})
+				cache.Set("c", c)
+			},
+			value: "T1",
+			want:  []string{"a"},
+		},
+		{
+			name:    "заказы значения не помещаются в кеш",
+			maxSize: 1,
+			run:     func(cache *LRUCache) { cache.SetComplete(OrderIndexTrackNumber, "T1", []Order{b, a}) },
+			value:   "T1",
+			want:    []string{"a"},
+		},
+		{
+			name: "в кеше более новая версия с другим значением",
+			run: func(cache *LRUCache) {
+				cache.Set("a", movedA)
+				cache.SetComplete(OrderIndexTrackNumber, "T1", []Order{b, a})
+			},
+			value: "T1",
+			want:  []string{"b"},
+		},
+		{
+			name: "истечение заказа значения",
+			ttl:  cacheTTL,
+			run: func(cache *LRUCache) {
+				cache.SetComplete(OrderIndexTrackNumber, "T1", []Order{a})
+				time.Sleep(2 * cacheTTL)
+				cache.SetIfNewer("b", b)
+			},
+			value: "T1",
+			want:  []string{"b"},
+		},
+		{
+			name: "удаление заказа значения",
+			run: func(cache *LRUCache) {
+				cache.SetComplete(OrderIndexTrackNumber, "T1", []Order{b, a})
+				cache.Delete("b")
+			},
+			value: "T1",
+			want:  []string{"a"},
+		},
+		{
+			name:  "пустой ответ бд не отмечается",
+			run:   func(cache *LRUCache) { cache.SetComplete(OrderIndexTrackNumber, "T3", nil) },
+			value: "T3",
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewLRUCache(tt.maxSize, tt.ttl)
+			tt.run(cache)
+
+			orders, complete := cache.Lookup(OrderIndexTrackNumber, tt.value)
+			if got := orderUIDs(orders); !reflect.DeepEqual(got, tt.want) || complete != tt.wantComplete {
+				t.Errorf("Lookup(%v) = %v, %v, want %v, %v", tt.value, got, complete, tt.want, tt.wantComplete)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// режимы правила согласованности: reject отклоняет заказ, warn только пишет предупреждение
+const (
+	RuleModeReject = "reject"
+	RuleModeWarn   = "warn"
+	RuleModeOff    = "off"
+)
+
+const (
+	RuleGoodsTotal      = "goods_total"
+	RuleAmount          = "amount"
+	RuleItemTotalPrice  = "item_total_price"
+	RuleItemTrackNumber = "item_track_number"
+	RuleTransaction     = "transaction"
+)
+
+// Validator проверяет поля заказа по текущим справочникам, а затем согласованность полей между собой
+type Validator struct {
+	Consistency ConsistencyConfig
+	// StrictSchema включает проверку сырого сообщения по схеме заказа до десериализации
+	StrictSchema bool
+
+	// справочники заменяются целиком, пока воркеры продолжают валидацию
+	rules atomic.Pointer[referenceRules]
+}
+
+// NewValidator создает валидатор со встроенными справочниками (DefaultReferenceRules)
+func NewValidator(cfg ConsistencyConfig) *Validator {
+	v := &Validator{Consistency: cfg}
+	v.rules.Store(builtinRules)
+	return v
+}
+
+// SetRules применяет новые справочники, если они корректны
+func (v *Validator) SetRules(rules ReferenceRules) error {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return err
+	}
+	v.rules.Store(compiled)
+	return nil
+}
+
+func (v *Validator) strictSchema() bool {
+	return v != nil && v.StrictSchema
+}
+
+func (v *Validator) currentRules() *referenceRules {
+	if rules := v.rules.Load(); rules != nil {
+		return rules
+	}
+	return builtinRules
+}
+
+// Validate проверяет правила согласованности, только если все поля по отдельности корректны,
+// иначе расхождения сумм были бы следствием уже найденных нарушений.
+// nil Validator проверяет только поля по встроенным справочникам
+func (v *Validator) Validate(order *Order) ValidationResult {
+	if v == nil {
+		return order.ValidateMessageData()
+	}
+
+	result := order.validateFields(v.currentRules())
+	if !result.Valid() {
+		return result
+	}
+
+	v.checkConsistency(order, &result)
+	return result
+}
+
+func (v *Validator) checkConsistency(order *Order, r *ValidationResult) {
+	rules := []struct {
+		name  string
+		mode  string
+		check func(order *Order, report func(path, format string, args ...any))
+	}{
+		{RuleGoodsTotal, v.Consistency.GoodsTotal, checkGoodsTotal},
+		{RuleAmount, v.Consistency.Amount, checkAmount},
+		{RuleItemTotalPrice, v.Consistency.ItemTotalPrice, checkItemTotalPrice},
+		{RuleItemTrackNumber, v.Consistency.ItemTrackNumber, checkItemTrackNumber},
+		{RuleTransaction, v.Consistency.Transaction, checkTransaction},
+	}
+
+	for _, rule := range rules {
+		if rule.mode == RuleModeOff {
+			continue
+		}
+
+		rule.check(order, func(path, format string, args ...any) {
+			violation := Violation{
+				Path:    path,
+				Code:    ViolationInconsistent,
+				Rule:    rule.name,
+				Message: fmt.Sprintf(format, args...),
+			}
+			consistencyViolations.WithLabelValues(rule.name, rule.mode).Inc()
+			if rule.mode == RuleModeWarn {
+				r.Warnings = append(r.Warnings, violation)
+				return
+			}
+			r.Violations = append(r.Violations, violation)
+		})
+	}
+}
+
+func checkGoodsTotal(order *Order, report func(path, format string, args ...any)) {
+	sum := NewMoney(0, order.Payment.Currency)
+	for _, item := range order.Items {
+		sum.Minor += item.TotalPrice.Minor
+	}
+	if order.Payment.GoodsTotal.Minor != sum.Minor {
+		report("payment.goods_total", "goods_total %v не равен сумме total_price товаров %v", order.Payment.GoodsTotal, sum)
+	}
+}
+
+func checkAmount(order *Order, report func(path, format string, args ...any)) {
+	payment := order.Payment
+	expected := NewMoney(payment.GoodsTotal.Minor+payment.DeliveryCost.Minor+payment.CustomFee.Minor, payment.Currency)
+	if payment.Amount.Minor != expected.Minor {
+		report("payment.amount", "amount %v не равен goods_total + delivery_cost + custom_fee = %v", order.Payment.Amount, expected)
+	}
+}
+
+// checkItemTotalPrice допускает только округление цены со скидкой до копейки в любую сторону
+// (NewItem округляет до ближайшей); скидка в процентах, поэтому сравнение идет в сотых долях копейки
+func checkItemTotalPrice(order *Order, report func(path, format string, args ...any)) {
+	for i, item := range order.Items {
+		discounted := item.Price.Minor * int64(100-item.Sale)
+		diff := item.TotalPrice.Minor*100 - discounted
+		if diff <= -100 || diff >= 100 {
+			report(fmt.Sprintf("items[%d].total_price", i), "total_price %v не соответствует price %v со скидкой %v%%", item.TotalPrice, item.Price, item.Sale)
+		}
+	}
+}
+
+func checkItemTrackNumber(order *Order, report func(path, format string, args ...any)) {
+	for i, item := range order.Items {
+		if item.TrackNumber != order.TrackNumber {
+			report(fmt.Sprintf("items[%d].track_number", i), "track_number товара %q не совпадает с track_number заказа %q", item.TrackNumber, order.TrackNumber)
+		}
+	}
+}
+
+func checkTransaction(order *Order, report func(path, format string, args ...any)) {
+	if order.Payment.Transaction != order.OrderUID {
+		report("payment.transaction", "transaction %q не совпадает с order_uid %q", order.Payment.Transaction, order.OrderUID)
+	}
+}
@@ -2,19 +2,45 @@ package internal
 
 import (
 	"context"
-	"log"
+	"log/slog"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// SubscribeOnTopic читает топик до отмены ctx, после чего закрывает канал messages,
+// чтобы воркер дообработал уже полученные сообщения и завершился
 func SubscribeOnTopic(ctx context.Context, reader *kafka.Reader, messages chan<- kafka.Message) {
+	defer close(messages)
+
 	for {
-		msg, err := reader.ReadMessage(ctx)
+		msg, err := reader.FetchMessage(ctx)
 		if err != nil {
-			log.Printf("message reading error: %v", err)
+			if ctx.Err() != nil {
+				Logger(ctx).Info("Чтение топика остановлено", slog.Any(LogKeyError, ctx.Err()))
+				return
+			}
+			Logger(ctx).Error("Ошибка чтения сообщения", slog.Any(LogKeyError, err))
 			continue
 		}
+		observeConsumed(msg)
+
+		// span receive продолжает trace продюсера и длится, пока сообщение ждет места в очереди воркеров;
+		// его контекст записывается обратно в заголовки, и span обработки становится его потомком
+		spanCtx, span := tracer.Start(ExtractTraceContext(ctx, msg), "orders receive",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			messageAttributes(msg),
+		)
+		InjectTraceContext(spanCtx, &msg)
 
-		messages <- msg
+		select {
+		case messages <- msg:
+			span.End()
+		case <-ctx.Done():
+			// offset сообщения не закоммичен, после перезапуска оно будет прочитано повторно
+			Logger(withMessage(ctx, msg)).Info("Чтение топика остановлено, сообщение не передано воркеру")
+			endSpan(span, ctx.Err())
+			return
+		}
 	}
 }
@@ -3,21 +3,28 @@ package internal
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
-func NewDB(connstring string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", connstring)
+var ErrOrderNotFound = errors.New("заказ не найден")
+
+func NewDB(cfg PostgresConfig) (*sql.DB, error) {
+	connConfig, err := pgx.ParseConfig(cfg.DSN())
 	if err != nil {
 		return nil, err
 	}
+	connConfig.Tracer = pgTracer{}
+	db := stdlib.OpenDB(*connConfig)
 
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 
 	err = db.Ping()
 	if err != nil {
@@ -27,466 +34,386 @@ func NewDB(connstring string) (*sql.DB, error) {
 	return db, nil
 }
 
-func saveOrder(ctx context.Context, db *sql.DB, order Order) error {
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("начало транзакции провалилось: %w. ", err)
-	}
-	defer tx.Rollback()
+// PostgresRepository - OrderRepository поверх postgres
+type PostgresRepository struct {
+	db *sql.DB
+}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO orders (
-			order_uid, track_number, entry, locale, internal_signature,
-			customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		ON CONFLICT (order_uid) DO NOTHING
-	`,
-		order.OrderUID, order.TrackNumber, order.Entry, order.Locale,
-		order.InternalSignature, order.CustomerID, order.DeliveryService,
-		order.Shardkey, order.SmID, order.DateCreated, order.OofShard,
-	)
-	if err != nil {
-		return fmt.Errorf("ошибка сохранения заказа: %w. ", err)
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+func (r *PostgresRepository) Save(ctx context.Context, writes []OrderWrite) error {
+	return saveOrders(ctx, r.db, writes)
+}
+
+func (r *PostgresRepository) GetByID(ctx context.Context, orderUID string) (Order, error) {
+	return getOrderByIdFromDB(ctx, r.db, orderUID)
+}
+
+func (r *PostgresRepository) List(ctx context.Context, opts ListOptions) ([]Order, error) {
+	if len(opts.OrderUIDs) > 0 {
+		return getOrdersByIDsFromDB(ctx, r.db, opts.OrderUIDs)
 	}
+	return getAlllOrders(ctx, r.db, opts.Limit)
+}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO delivery (order_uid, name, phone, zip, city, address, region, email)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		ON CONFLICT (order_uid) DO NOTHING
-	`,
-		order.OrderUID, order.Delivery.Name, order.Delivery.Phone,
-		order.Delivery.Zip, order.Delivery.City, order.Delivery.Address,
-		order.Delivery.Region, order.Delivery.Email,
-	)
-	if err != nil {
-		return fmt.Errorf("ошибка сохранения данных доставки: %w. ", err)
+// Stream читает заказы страницами по ключу (date_created, order_uid) от новых к старым:
+// в памяти одновременно только одна страница, а каждый запрос идет по индексу без OFFSET
+func (r *PostgresRepository) Stream(ctx context.Context, opts ListOptions, fn func(Order) error) error {
+	if len(opts.OrderUIDs) > 0 {
+		orders, err := getOrdersByIDsFromDB(ctx, r.db, opts.OrderUIDs)
+		if err != nil {
+			return err
+		}
+		for _, order := range orders {
+			if err := fn(order); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
-	paymentTime := time.Unix(order.Payment.PaymentDt, 0)
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO payment (
-			order_uid, transaction, request_id, currency, provider,
-			amount, payment_dt, bank, delivery_cost, goods_total, custom_fee
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		ON CONFLICT (order_uid) DO NOTHING
-	`,
-		order.OrderUID, order.Payment.Transaction, order.Payment.RequestID,
-		order.Payment.Currency, order.Payment.Provider, order.Payment.Amount,
-		paymentTime, order.Payment.Bank, order.Payment.DeliveryCost,
-		order.Payment.GoodsTotal, order.Payment.CustomFee,
-	)
-	if err != nil {
-		return fmt.Errorf("ошибка сохранения данных оплаты: %w. ", err)
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultStreamBatchSize
 	}
 
-	for _, item := range order.Items {
-		_, err := tx.ExecContext(ctx, `
-        INSERT INTO items (chrt_id, name, size, nm_id, brand)
-        VALUES ($1, $2, $3, $4, $5)
-        ON CONFLICT (chrt_id) DO NOTHING
-    	`, item.ChrtID, item.Name, item.Size, item.NmID, item.Brand,
-		)
+	var after *orderKey
+	streamed := 0
+	for {
+		size := batchSize
+		if opts.Limit > 0 && opts.Limit-streamed < size {
+			size = opts.Limit - streamed
+		}
+		if size <= 0 {
+			return nil
+		}
+
+		keys, err := getOrderKeysPage(ctx, r.db, after, size)
 		if err != nil {
-			return fmt.Errorf("ошибка сохранения товаров заказа: %w. ", err)
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
 		}
 
-		_, err = tx.ExecContext(ctx, `
-			INSERT INTO order_items (
-				order_uid, chrt_id, track_number, price, sale, total_price, rid, status
-			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-			ON CONFLICT (order_uid, chrt_id, rid) DO NOTHING 
-		`,
-			order.OrderUID, item.ChrtID, item.TrackNumber,
-			item.Price, item.Sale, item.TotalPrice,
-			item.Rid, item.Status,
-		)
+		uids := make([]string, len(keys))
+		for i, key := range keys {
+			uids[i] = key.orderUID
+		}
+		orders, err := getOrdersByIDsFromDB(ctx, r.db, uids)
 		if err != nil {
-			return fmt.Errorf("ошибка вставки в order_items: %w. ", err)
+			return err
+		}
+		byUID := make(map[string]Order, len(orders))
+		for _, order := range orders {
+			byUID[order.OrderUID] = order
+		}
+
+		for _, key := range keys {
+			// заказ мог быть удален между запросами страницы и самих заказов
+			order, ok := byUID[key.orderUID]
+			if !ok {
+				continue
+			}
+			if err := fn(order); err != nil {
+				return err
+			}
 		}
+
+		streamed += len(keys)
+		if len(keys) < size {
+			return nil
+		}
+		after = &keys[len(keys)-1]
 	}
+}
 
-	return tx.Commit()
+type orderKey struct {
+	dateCreated time.Time
+	orderUID    string
 }
 
-func getOrderByIdFromDB(ctx context.Context, db *sql.DB, order_id string) (Order, error) {
-	query := `
-        SELECT
-            o.order_uid,
-            o.track_number,
-            o.entry,
-            o.locale,
-            o.internal_signature,
-            o.customer_id,
-            o.delivery_service,
-            o.shardkey,
-            o.sm_id,
-            o.date_created,
-            o.oof_shard,
-            d.name AS delivery_name,
-            d.phone AS delivery_phone,
-            d.zip AS delivery_zip,
-            d.city AS delivery_city,
-            d.address AS delivery_address,
-            d.region AS delivery_region,
-            d.email AS delivery_email,
-            p.transaction,
-            p.request_id,
-            p.currency,
-            p.provider,
-            p.amount,
-            p.payment_dt,
-            p.bank,
-            p.delivery_cost,
-            p.goods_total,
-            p.custom_fee,
-            i.chrt_id,
-            i.name AS item_name,
-            i.size AS item_size,
-            i.nm_id,
-            i.brand,
-            oi.track_number AS item_track_number,
-            oi.price,
-            oi.sale,
-            oi.total_price AS item_total_price,
-            oi.rid,
-            oi.status
-        FROM orders o
-        LEFT JOIN delivery d ON o.order_uid = d.order_uid
-        LEFT JOIN payment p ON o.order_uid = p.order_uid
-        LEFT JOIN order_items oi ON o.order_uid = oi.order_uid
-        LEFT JOIN items i ON oi.chrt_id = i.chrt_id
-        WHERE o.order_uid = $1
-        ORDER BY i.chrt_id, oi.rid;
-    `
-
-	rows, err := db.QueryContext(ctx, query, order_id)
+// getOrderKeysPage возвращает следующую страницу ключей заказов после after (nil - с начала)
+func getOrderKeysPage(ctx context.Context, db *sql.DB, after *orderKey, limit int) ([]orderKey, error) {
+	var rows *sql.Rows
+	var err error
+	if after == nil {
+		rows, err = db.QueryContext(ctx, `
+			SELECT date_created, order_uid FROM orders
+			ORDER BY date_created DESC, order_uid DESC
+			LIMIT $1
+		`, limit)
+	} else {
+		rows, err = db.QueryContext(ctx, `
+			SELECT date_created, order_uid FROM orders
+			WHERE (date_created, order_uid) < ($1, $2)
+			ORDER BY date_created DESC, order_uid DESC
+			LIMIT $3
+		`, after.dateCreated, after.orderUID, limit)
+	}
 	if err != nil {
-		return Order{}, fmt.Errorf("ошибка выполнения запроса: %w. ", err)
+		return nil, fmt.Errorf("ошибка чтения страницы заказов: %w. ", err)
 	}
 	defer rows.Close()
 
-	var order Order
-	var firstRow = true
-
+	keys := make([]orderKey, 0, limit)
 	for rows.Next() {
-		var r dbRow
-		err := rows.Scan(
-			&r.OrderUID, &r.TrackNumber, &r.Entry, &r.Locale, &r.InternalSignature,
-			&r.CustomerID, &r.DeliveryService, &r.Shardkey, &r.SmID, &r.DateCreated, &r.OofShard,
-			&r.DeliveryName, &r.DeliveryPhone, &r.DeliveryZip, &r.DeliveryCity,
-			&r.DeliveryAddress, &r.DeliveryRegion, &r.DeliveryEmail,
-			&r.Transaction, &r.RequestID, &r.Currency, &r.Provider,
-			&r.Amount, &r.PaymentDt, &r.Bank, &r.DeliveryCost, &r.GoodsTotal, &r.CustomFee,
-			&r.ChrtID, &r.ItemName, &r.ItemSize, &r.NmID, &r.Brand,
-			&r.ItemTrackNumber, &r.Price, &r.Sale, &r.ItemTotalPrice, &r.Rid, &r.Status,
-		)
+		var key orderKey
+		err := rows.Scan(&key.dateCreated, &key.orderUID)
 		if err != nil {
-			return Order{}, fmt.Errorf("ошибка сканирования строки: %w. ", err)
+			return nil, fmt.Errorf("ошибка сканирования строки: %w. ", err)
 		}
-		if firstRow {
-			order.OrderUID = r.OrderUID
-			order.TrackNumber = r.TrackNumber
-			order.Entry = r.Entry
-			order.Locale = r.Locale
-			order.InternalSignature = nullStringOrEmpty(r.InternalSignature)
-			order.CustomerID = r.CustomerID
-			order.DeliveryService = r.DeliveryService
-			order.Shardkey = r.Shardkey
-			order.SmID = r.SmID
-			order.DateCreated = r.DateCreated.Format(time.RFC3339)
-			order.OofShard = r.OofShard
-			order.Delivery = struct {
-				Name    string `json:"name"`
-				Phone   string `json:"phone"`
-				Zip     string `json:"zip"`
-				City    string `json:"city"`
-				Address string `json:"address"`
-				Region  string `json:"region"`
-				Email   string `json:"email"`
-			}{
-				Name:    nullStringOrEmpty(r.DeliveryName),
-				Phone:   nullStringOrEmpty(r.DeliveryPhone),
-				Zip:     nullStringOrEmpty(r.DeliveryZip),
-				City:    nullStringOrEmpty(r.DeliveryCity),
-				Address: nullStringOrEmpty(r.DeliveryAddress),
-				Region:  nullStringOrEmpty(r.DeliveryRegion),
-				Email:   nullStringOrEmpty(r.DeliveryEmail),
-			}
-			order.Payment = struct {
-				Transaction  string `json:"transaction"`
-				RequestID    string `json:"request_id"`
-				Currency     string `json:"currency"`
-				Provider     string `json:"provider"`
-				Amount       int    `json:"amount"`
-				PaymentDt    int64  `json:"payment_dt"`
-				Bank         string `json:"bank"`
-				DeliveryCost int    `json:"delivery_cost"`
-				GoodsTotal   int    `json:"goods_total"`
-				CustomFee    int    `json:"custom_fee"`
-			}{
-				Transaction:  nullStringOrEmpty(r.Transaction),
-				RequestID:    nullStringOrEmpty(r.RequestID),
-				Currency:     nullStringOrEmpty(r.Currency),
-				Provider:     nullStringOrEmpty(r.Provider),
-				Amount:       int(nullFloat64OrZero(r.Amount)),
-				PaymentDt:    r.PaymentDt.Time.Unix(),
-				Bank:         nullStringOrEmpty(r.Bank),
-				DeliveryCost: int(nullFloat64OrZero(r.DeliveryCost)),
-				GoodsTotal:   int(nullFloat64OrZero(r.GoodsTotal)),
-				CustomFee:    int(nullFloat64OrZero(r.CustomFee)),
-			}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка при итерации по строкам: %w", err)
+	}
 
-			firstRow = false
-		}
+	return keys, nil
+}
 
-		if r.ChrtID.Valid {
-			item := struct {
-				ChrtID      int    `json:"chrt_id"`
-				TrackNumber string `json:"track_number"`
-				Price       int    `json:"price"`
-				Rid         string `json:"rid"`
-				Name        string `json:"name"`
-				Sale        int    `json:"sale"`
-				Size        string `json:"size"`
-				TotalPrice  int    `json:"total_price"`
-				NmID        int    `json:"nm_id"`
-				Brand       string `json:"brand"`
-				Status      int    `json:"status"`
-			}{
-				ChrtID:      int(r.ChrtID.Int64),
-				TrackNumber: nullStringOrEmpty(r.ItemTrackNumber),
-				Price:       int(nullFloat64OrZero(r.Price)),
-				Rid:         nullStringOrEmpty(r.Rid),
-				Name:        nullStringOrEmpty(r.ItemName),
-				Sale:        int(nullInt64OrZero(r.Sale)),
-				Size:        nullStringOrEmpty(r.ItemSize),
-				TotalPrice:  int(nullFloat64OrZero(r.ItemTotalPrice)),
-				NmID:        int(r.NmID.Int64),
-				Brand:       nullStringOrEmpty(r.Brand),
-				Status:      int(r.Status.Int64),
-			}
-			order.Items = append(order.Items, item)
-		}
+// Delete удаляет заказ; доставка, платеж и товары заказа удаляются каскадно, order_history не затрагивается
+func (r *PostgresRepository) Delete(ctx context.Context, orderUID string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM orders WHERE order_uid = $1`, orderUID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления заказа %v: %w. ", orderUID, err)
 	}
 
-	if err = rows.Err(); err != nil {
-		return Order{}, fmt.Errorf("ошибка при итерации по строкам: %w", err)
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ошибка удаления заказа %v: %w. ", orderUID, err)
 	}
-
-	if firstRow {
-		return Order{}, fmt.Errorf("заказ с order_uid=%s не найден", order_id)
+	if deleted == 0 {
+		return fmt.Errorf("заказ с order_uid=%s: %w", orderUID, ErrOrderNotFound)
 	}
+	return nil
+}
 
-	return order, nil
+func (r *PostgresRepository) History(ctx context.Context, orderUID string) ([]OrderHistoryEntry, error) {
+	return getOrderHistoryFromDB(ctx, r.db, orderUID)
 }
 
-func getAlllOrders(ctx context.Context, db *sql.DB) ([]Order, error) {
-	query := `
-	SELECT
-		o.order_uid,
-		o.track_number,
-		o.entry,
-		o.locale,
-		o.internal_signature,
-		o.customer_id,
-		o.delivery_service,
-		o.shardkey,
-		o.sm_id,
-		o.date_created,
-		o.oof_shard,
-
-		d.name AS delivery_name,
-		d.phone AS delivery_phone,
-		d.zip AS delivery_zip,
-		d.city AS delivery_city,
-		d.address AS delivery_address,
-		d.region AS delivery_region,
-		d.email AS delivery_email,
-
-		p.transaction,
-		p.request_id,
-		p.currency,
-		p.provider,
-		p.amount,
-		p.payment_dt,
-		p.bank,
-		p.delivery_cost,
-		p.goods_total,
-		p.custom_fee,
-
-		oi.chrt_id,
-		i.name AS item_name,
-		i.size,
-		i.nm_id,
-		i.brand,
-		oi.track_number AS item_track_number,
-		oi.price,
-		oi.sale,
-		oi.total_price AS item_total_price,
-		oi.rid,
-		oi.status
-	FROM orders o
-	LEFT JOIN delivery d ON o.order_uid = d.order_uid
-	LEFT JOIN payment p ON o.order_uid = p.order_uid
-	LEFT JOIN order_items oi ON o.order_uid = oi.order_uid
-	LEFT JOIN items i ON oi.chrt_id = i.chrt_id
-	ORDER BY o.order_uid;
-`
-
-	rows, err := db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
-	}
-	defer rows.Close()
+func (r *PostgresRepository) ListBy(ctx context.Context, index OrderIndex, value string) ([]Order, error) {
+	return getOrdersByIndexFromDB(ctx, r.db, index, value)
+}
 
-	ordersMap := make(map[string]Order)
+func (r *PostgresRepository) Search(ctx context.Context, search OrderSearch) (OrderPage, error) {
+	return searchOrdersInDB(ctx, r.db, search)
+}
 
-	for rows.Next() {
-		var r dbRow
-		err := rows.Scan(
-			&r.OrderUID, &r.TrackNumber, &r.Entry, &r.Locale, &r.InternalSignature,
-			&r.CustomerID, &r.DeliveryService, &r.Shardkey, &r.SmID, &r.DateCreated, &r.OofShard,
-			&r.DeliveryName, &r.DeliveryPhone, &r.DeliveryZip, &r.DeliveryCity, &r.DeliveryAddress,
-			&r.DeliveryRegion, &r.DeliveryEmail,
-			&r.Transaction, &r.RequestID, &r.Currency, &r.Provider, &r.Amount, &r.PaymentDt,
-			&r.Bank, &r.DeliveryCost, &r.GoodsTotal, &r.CustomFee,
-			&r.ChrtID, &r.ItemName, &r.ItemSize, &r.NmID, &r.Brand, &r.ItemTrackNumber,
-			&r.Price, &r.Sale, &r.ItemTotalPrice, &r.Rid, &r.Status,
-		)
+// saveOrders сохраняет заказы одной транзакцией: все запросы отправляются в postgres одним pgx.Batch
+func saveOrders(ctx context.Context, db *sql.DB, writes []OrderWrite) error {
+	batch := &pgx.Batch{}
+	for _, write := range writes {
+		err := queueOrder(batch, write.Order)
 		if err != nil {
-			return nil, fmt.Errorf("ошибка сканирования строки: %w", err)
+			return err
 		}
 
-		order, exists := ordersMap[r.OrderUID]
-		if !exists {
-			order = Order{
-				OrderUID:          r.OrderUID,
-				TrackNumber:       r.TrackNumber,
-				Entry:             r.Entry,
-				Locale:            r.Locale,
-				InternalSignature: nullStringOrEmpty(r.InternalSignature),
-				CustomerID:        r.CustomerID,
-				DeliveryService:   r.DeliveryService,
-				Shardkey:          r.Shardkey,
-				SmID:              r.SmID,
-				DateCreated:       r.DateCreated.Format(time.RFC3339),
-				OofShard:          r.OofShard,
-				Items: make([]struct {
-					ChrtID      int    `json:"chrt_id"`
-					TrackNumber string `json:"track_number"`
-					Price       int    `json:"price"`
-					Rid         string `json:"rid"`
-					Name        string `json:"name"`
-					Sale        int    `json:"sale"`
-					Size        string `json:"size"`
-					TotalPrice  int    `json:"total_price"`
-					NmID        int    `json:"nm_id"`
-					Brand       string `json:"brand"`
-					Status      int    `json:"status"`
-				}, 0),
+		if write.History != nil {
+			err = queueHistory(batch, *write.History)
+			if err != nil {
+				return err
 			}
+		}
+	}
 
-			if r.DeliveryName.Valid {
-				order.Delivery = struct {
-					Name    string `json:"name"`
-					Phone   string `json:"phone"`
-					Zip     string `json:"zip"`
-					City    string `json:"city"`
-					Address string `json:"address"`
-					Region  string `json:"region"`
-					Email   string `json:"email"`
-				}{
-					Name:    r.DeliveryName.String,
-					Phone:   nullStringOrEmpty(r.DeliveryPhone),
-					Zip:     nullStringOrEmpty(r.DeliveryZip),
-					City:    nullStringOrEmpty(r.DeliveryCity),
-					Address: nullStringOrEmpty(r.DeliveryAddress),
-					Region:  nullStringOrEmpty(r.DeliveryRegion),
-					Email:   nullStringOrEmpty(r.DeliveryEmail),
-				}
-			}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения соединения из пула: %w. ", err)
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
 
-			if r.Transaction.Valid {
-				order.Payment = struct {
-					Transaction  string `json:"transaction"`
-					RequestID    string `json:"request_id"`
-					Currency     string `json:"currency"`
-					Provider     string `json:"provider"`
-					Amount       int    `json:"amount"`
-					PaymentDt    int64  `json:"payment_dt"`
-					Bank         string `json:"bank"`
-					DeliveryCost int    `json:"delivery_cost"`
-					GoodsTotal   int    `json:"goods_total"`
-					CustomFee    int    `json:"custom_fee"`
-				}{
-					Transaction:  r.Transaction.String,
-					RequestID:    nullStringOrEmpty(r.RequestID),
-					Currency:     nullStringOrEmpty(r.Currency),
-					Provider:     nullStringOrEmpty(r.Provider),
-					Amount:       int(r.Amount.Float64),
-					PaymentDt:    r.PaymentDt.Time.Unix(),
-					Bank:         nullStringOrEmpty(r.Bank),
-					DeliveryCost: int(nullFloat64OrZero(r.DeliveryCost)),
-					GoodsTotal:   int(nullFloat64OrZero(r.GoodsTotal)),
-					CustomFee:    int(nullFloat64OrZero(r.CustomFee)),
-				}
+		return pgx.BeginFunc(ctx, pgxConn, func(tx pgx.Tx) error {
+			err := tx.SendBatch(ctx, batch).Close()
+			if err != nil {
+				return fmt.Errorf("ошибка сохранения пачки заказов: %w. ", err)
 			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
 
-			ordersMap[r.OrderUID] = order
+	logger := Logger(ctx)
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		for _, write := range writes {
+			attrs := []any{slog.String(LogKeyOrderUID, write.Order.OrderUID)}
+			if write.History != nil {
+				attrs = append(attrs,
+					slog.Int(LogKeyPartition, write.History.KafkaPartition),
+					slog.Int64(LogKeyOffset, write.History.KafkaOffset),
+				)
+			}
+			logger.Debug("Заказ сохранен в бд", attrs...)
 		}
+	}
+	return nil
+}
 
-		if r.ChrtID.Valid {
-			order.Items = append(order.Items, struct {
-				ChrtID      int    `json:"chrt_id"`
-				TrackNumber string `json:"track_number"`
-				Price       int    `json:"price"`
-				Rid         string `json:"rid"`
-				Name        string `json:"name"`
-				Sale        int    `json:"sale"`
-				Size        string `json:"size"`
-				TotalPrice  int    `json:"total_price"`
-				NmID        int    `json:"nm_id"`
-				Brand       string `json:"brand"`
-				Status      int    `json:"status"`
-			}{
-				ChrtID:      int(r.ChrtID.Int64),
-				TrackNumber: nullStringOrEmpty(r.ItemTrackNumber),
-				Price:       int(r.Price.Float64),
-				Rid:         nullStringOrEmpty(r.Rid),
-				Name:        nullStringOrEmpty(r.ItemName),
-				Sale:        int(r.Sale.Int64),
-				Size:        nullStringOrEmpty(r.ItemSize),
-				TotalPrice:  int(r.ItemTotalPrice.Float64),
-				NmID:        int(r.NmID.Int64),
-				Brand:       nullStringOrEmpty(r.Brand),
-				Status:      int(r.Status.Int64),
-			})
-		}
+func queueOrder(batch *pgx.Batch, order Order) error {
+	dateCreated, err := time.Parse(time.RFC3339, order.DateCreated)
+	if err != nil {
+		return fmt.Errorf("некорректный date_created заказа %v: %w. ", order.OrderUID, err)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("ошибка итерирования по строкам: %w", err)
+	// более старое событие не перезаписывает заказ; дочерние таблицы обновляются,
+	// только если заказ обновлен этим же событием (event_time совпадает)
+	batch.Queue(`
+		INSERT INTO orders (
+			order_uid, track_number, entry, locale, internal_signature,
+			customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, event_time
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (order_uid) DO UPDATE SET
+			track_number = EXCLUDED.track_number,
+			entry = EXCLUDED.entry,
+			locale = EXCLUDED.locale,
+			internal_signature = EXCLUDED.internal_signature,
+			customer_id = EXCLUDED.customer_id,
+			delivery_service = EXCLUDED.delivery_service,
+			shardkey = EXCLUDED.shardkey,
+			sm_id = EXCLUDED.sm_id,
+			date_created = EXCLUDED.date_created,
+			oof_shard = EXCLUDED.oof_shard,
+			event_time = EXCLUDED.event_time
+		WHERE orders.event_time IS NULL OR orders.event_time <= EXCLUDED.event_time
+	`,
+		order.OrderUID, order.TrackNumber, order.Entry, order.Locale,
+		order.InternalSignature, order.CustomerID, order.DeliveryService,
+		order.Shardkey, order.SmID, dateCreated, order.OofShard, order.EventTime,
+	)
+
+	batch.Queue(`
+		INSERT INTO delivery (order_uid, name, phone, zip, city, address, region, email)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (order_uid) DO UPDATE SET
+			name = EXCLUDED.name,
+			phone = EXCLUDED.phone,
+			zip = EXCLUDED.zip,
+			city = EXCLUDED.city,
+			address = EXCLUDED.address,
+			region = EXCLUDED.region,
+			email = EXCLUDED.email
+		WHERE (SELECT event_time FROM orders WHERE order_uid = EXCLUDED.order_uid) = $9
+	`, append(order.Delivery.rowValues(order.OrderUID), order.EventTime)...)
+
+	batch.Queue(`
+		INSERT INTO payment (
+			order_uid, transaction, request_id, currency, provider,
+			amount, payment_dt, bank, delivery_cost, goods_total, custom_fee
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (order_uid) DO UPDATE SET
+			transaction = EXCLUDED.transaction,
+			request_id = EXCLUDED.request_id,
+			currency = EXCLUDED.currency,
+			provider = EXCLUDED.provider,
+			amount = EXCLUDED.amount,
+			payment_dt = EXCLUDED.payment_dt,
+			bank = EXCLUDED.bank,
+			delivery_cost = EXCLUDED.delivery_cost,
+			goods_total = EXCLUDED.goods_total,
+			custom_fee = EXCLUDED.custom_fee
+		WHERE (SELECT event_time FROM orders WHERE order_uid = EXCLUDED.order_uid) = $12
+	`, append(order.Payment.rowValues(order.OrderUID), order.EventTime)...)
+
+	// товары заказа заменяются целиком: позиции, которых нет в новой версии, удаляются
+	batch.Queue(`
+		DELETE FROM order_items
+		WHERE order_uid = $1
+			AND (SELECT event_time FROM orders WHERE order_uid = $1) = $2
+	`, order.OrderUID, order.EventTime)
+
+	for i, item := range order.Items {
+		batch.Queue(`
+			INSERT INTO items (chrt_id, name, size, nm_id, brand)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (chrt_id) DO UPDATE SET
+				name = EXCLUDED.name,
+				size = EXCLUDED.size,
+				nm_id = EXCLUDED.nm_id,
+				brand = EXCLUDED.brand
+			WHERE (SELECT event_time FROM orders WHERE order_uid = $6) = $7
+		`, append(item.itemRowValues(), order.OrderUID, order.EventTime)...)
+
+		batch.Queue(`
+			INSERT INTO order_items (
+				order_uid, chrt_id, track_number, price, sale, total_price, rid, status, position
+			)
+			SELECT $1::text, $2::integer, $3::text, $4::numeric, $5::integer, $6::numeric, $7::text, $8::integer, $9::integer
+			WHERE (SELECT event_time FROM orders WHERE order_uid = $1::text) = $10::timestamptz
+			ON CONFLICT (order_uid, chrt_id, rid) DO UPDATE SET
+				track_number = EXCLUDED.track_number,
+				price = EXCLUDED.price,
+				sale = EXCLUDED.sale,
+				total_price = EXCLUDED.total_price,
+				status = EXCLUDED.status,
+				position = EXCLUDED.position
+		`, append(item.orderItemRowValues(order.OrderUID, i), order.EventTime)...)
 	}
 
-	var allOrders []Order
-	for _, order := range ordersMap {
-		allOrders = append(allOrders, order)
+	return nil
+}
+
+func getOrderByIdFromDB(ctx context.Context, db *sql.DB, order_id string) (Order, error) {
+	orders, err := getOrdersByIDsFromDB(ctx, db, []string{order_id})
+	if err != nil {
+		return Order{}, err
 	}
 
-	return allOrders, nil
+	if len(orders) == 0 {
+		return Order{}, fmt.Errorf("заказ с order_uid=%s: %w", order_id, ErrOrderNotFound)
+	}
+
+	return orders[0], nil
+}
+
+// getOrdersByIDsFromDB возвращает найденные заказы в порядке order_uid, отсутствующие пропускаются
+func getOrdersByIDsFromDB(ctx context.Context, db *sql.DB, orderUIDs []string) ([]Order, error) {
+	return queryOrders(ctx, db, orderRowsQuery+`
+		WHERE o.order_uid = ANY($1)
+		ORDER BY o.order_uid, `+orderItemsOrder, orderUIDs)
 }
+
+// getOrdersByIndexFromDB возвращает заказы со значением вторичного ключа; индексы колонок - миграции 007 и 008
+func getOrdersByIndexFromDB(ctx context.Context, db *sql.DB, index OrderIndex, value string) ([]Order, error) {
+	column, err := index.column()
+	if err != nil {
+		return nil, err
+	}
+
+	return queryOrders(ctx, db, orderRowsQuery+`
+		WHERE `+column+` = $1
+		ORDER BY o.date_created DESC, o.order_uid DESC, `+orderItemsOrder, value)
+}
+
+// getAlllOrders возвращает limit последних заказов от новых к старым в том же порядке, что и Stream;
+// limit <= 0 возвращает все заказы
+func getAlllOrders(ctx context.Context, db *sql.DB, limit int) ([]Order, error) {
+	// LIMIT NULL в postgres означает отсутствие ограничения
+	var rowsLimit sql.NullInt64
+	if limit > 0 {
+		rowsLimit = sql.NullInt64{Int64: int64(limit), Valid: true}
+	}
+
+	return queryOrders(ctx, db, orderRowsQuery+`
+		WHERE o.order_uid IN (
+			SELECT order_uid FROM orders ORDER BY date_created DESC, order_uid DESC LIMIT $1
+		)
+		ORDER BY o.date_created DESC, o.order_uid DESC, `+orderItemsOrder, rowsLimit)
+}
+
 func nullStringOrEmpty(s sql.NullString) string {
 	if s.Valid {
 		return s.String
 	}
 	return ""
 }
-func nullFloat64OrZero(f sql.NullFloat64) float64 {
-	if f.Valid {
-		return f.Float64
-	}
-	return 0
-}
 func nullInt64OrZero(i sql.NullInt64) int64 {
 	if i.Valid {
 		return i.Int64
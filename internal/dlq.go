@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	StageUnmarshal = "unmarshal"
+	StageValidate  = "validate"
+	StageSave      = "save"
+)
+
+// заголовки, которые добавляются к сообщению при отправке в dead-letter топик
+const (
+	HeaderDLQError           = "dlq-error"
+	HeaderDLQStage           = "dlq-stage"
+	HeaderDLQAttempt         = "dlq-attempt"
+	HeaderDLQSourceTopic     = "dlq-source-topic"
+	HeaderDLQSourcePartition = "dlq-source-partition"
+	HeaderDLQSourceOffset    = "dlq-source-offset"
+	HeaderDLQFailedAt        = "dlq-failed-at"
+	HeaderDLQViolations      = "dlq-violations" // json массив нарушений, если сообщение не прошло валидацию
+)
+
+type ProcessingError struct {
+	Stage string
+	Err   error
+}
+
+func (e *ProcessingError) Error() string {
+	return fmt.Sprintf("этап %s: %v", e.Stage, e.Err)
+}
+
+func (e *ProcessingError) Unwrap() error {
+	return e.Err
+}
+
+type DeadLetterQueue struct {
+	writer *kafka.Writer
+}
+
+func NewDeadLetterQueue(brokers []string, topic string) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+func (q *DeadLetterQueue) Publish(ctx context.Context, msg kafka.Message, stage string, attempt int, cause error) error {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+7)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: HeaderDLQError, Value: []byte(cause.Error())},
+		kafka.Header{Key: HeaderDLQStage, Value: []byte(stage)},
+		kafka.Header{Key: HeaderDLQAttempt, Value: []byte(strconv.Itoa(attempt))},
+		kafka.Header{Key: HeaderDLQSourceTopic, Value: []byte(msg.Topic)},
+		kafka.Header{Key: HeaderDLQSourcePartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: HeaderDLQSourceOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: HeaderDLQFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+
+	var validationErr *ValidationError
+	if errors.As(cause, &validationErr) {
+		violations, err := json.Marshal(validationErr.Violations)
+		if err == nil {
+			headers = append(headers, kafka.Header{Key: HeaderDLQViolations, Value: violations})
+		}
+	}
+
+	// topic и partition исходного сообщения не передаются: writer сам выбирает их для dead-letter топика
+	dead := kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    msg.Time,
+	}
+	// trace context заменяется на span обработки, в котором сообщение было отклонено
+	InjectTraceContext(ctx, &dead)
+
+	err := q.writer.WriteMessages(ctx, dead)
+	if err != nil {
+		return fmt.Errorf("ошибка отправки сообщения в dead-letter топик: %w", err)
+	}
+
+	return nil
+}
+
+func (q *DeadLetterQueue) Close() error {
+	return q.writer.Close()
+}
@@ -2,57 +2,106 @@ package internal
 
 import (
 	"database/sql"
-	"fmt"
-	"log"
+	"encoding/json"
 	"time"
 )
 
 type Order struct {
-	OrderUID    string `json:"order_uid"`
+	OrderUID          string   `json:"order_uid"`
+	TrackNumber       string   `json:"track_number"`
+	Entry             string   `json:"entry"`
+	Delivery          Delivery `json:"delivery"`
+	Payment           Payment  `json:"payment"`
+	Items             []Item   `json:"items"`
+	Locale            string   `json:"locale"`
+	InternalSignature string   `json:"internal_signature"`
+	CustomerID        string   `json:"customer_id"`
+	DeliveryService   string   `json:"delivery_service"`
+	Shardkey          string   `json:"shardkey"`
+	SmID              int      `json:"sm_id"`
+	DateCreated       string   `json:"date_created"`
+	OofShard          string   `json:"oof_shard"`
+
+	// время события, из которого получена эта версия заказа; не входит в сообщение
+	EventTime time.Time `json:"-"`
+}
+
+type Delivery struct {
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	Zip     string `json:"zip"`
+	City    string `json:"city"`
+	Address string `json:"address"`
+	Region  string `json:"region"`
+	Email   string `json:"email"`
+}
+
+type Payment struct {
+	Transaction  string `json:"transaction"`
+	RequestID    string `json:"request_id"`
+	Currency     string `json:"currency"`
+	Provider     string `json:"provider"`
+	Amount       Money  `json:"amount"`
+	PaymentDt    int64  `json:"payment_dt"`
+	Bank         string `json:"bank"`
+	DeliveryCost Money  `json:"delivery_cost"`
+	GoodsTotal   Money  `json:"goods_total"`
+	CustomFee    Money  `json:"custom_fee"`
+}
+
+type Item struct {
+	ChrtID      int    `json:"chrt_id"`
 	TrackNumber string `json:"track_number"`
-	Entry       string `json:"entry"`
-	Delivery    struct {
-		Name    string `json:"name"`
-		Phone   string `json:"phone"`
-		Zip     string `json:"zip"`
-		City    string `json:"city"`
-		Address string `json:"address"`
-		Region  string `json:"region"`
-		Email   string `json:"email"`
-	} `json:"delivery"`
-	Payment struct {
-		Transaction  string `json:"transaction"`
-		RequestID    string `json:"request_id"`
-		Currency     string `json:"currency"`
-		Provider     string `json:"provider"`
-		Amount       int    `json:"amount"`
-		PaymentDt    int64  `json:"payment_dt"`
-		Bank         string `json:"bank"`
-		DeliveryCost int    `json:"delivery_cost"`
-		GoodsTotal   int    `json:"goods_total"`
-		CustomFee    int    `json:"custom_fee"`
-	} `json:"payment"`
-	Items []struct {
-		ChrtID      int    `json:"chrt_id"`
-		TrackNumber string `json:"track_number"`
-		Price       int    `json:"price"`
-		Rid         string `json:"rid"`
-		Name        string `json:"name"`
-		Sale        int    `json:"sale"`
-		Size        string `json:"size"`
-		TotalPrice  int    `json:"total_price"`
-		NmID        int    `json:"nm_id"`
-		Brand       string `json:"brand"`
-		Status      int    `json:"status"`
-	} `json:"items"`
-	Locale            string `json:"locale"`
-	InternalSignature string `json:"internal_signature"`
-	CustomerID        string `json:"customer_id"`
-	DeliveryService   string `json:"delivery_service"`
-	Shardkey          string `json:"shardkey"`
-	SmID              int    `json:"sm_id"`
-	DateCreated       string `json:"date_created"`
-	OofShard          string `json:"oof_shard"`
+	Price       Money  `json:"price"`
+	Rid         string `json:"rid"`
+	Name        string `json:"name"`
+	Sale        int    `json:"sale"`
+	Size        string `json:"size"`
+	TotalPrice  Money  `json:"total_price"`
+	NmID        int    `json:"nm_id"`
+	Brand       string `json:"brand"`
+	Status      int    `json:"status"`
+}
+
+// NewItem создает товар, total_price которого - цена со скидкой sale процентов,
+// округленная до копейки
+func NewItem(chrtID int, price Money, sale int) Item {
+	discounted := (price.Minor*int64(100-sale) + 50) / 100
+	return Item{
+		ChrtID:     chrtID,
+		Price:      price,
+		Sale:       sale,
+		TotalPrice: NewMoney(discounted, price.Currency),
+	}
+}
+
+// NewPayment создает платеж в валюте currency, согласованный с товарами:
+// goods_total - сумма total_price товаров, amount - goods_total + delivery_cost + custom_fee
+func NewPayment(transaction, currency string, items []Item, deliveryCost, customFee Money) Payment {
+	goodsTotal := NewMoney(0, currency)
+	for _, item := range items {
+		goodsTotal.Minor += item.TotalPrice.Minor
+	}
+
+	return Payment{
+		Transaction:  transaction,
+		Currency:     currency,
+		Amount:       NewMoney(goodsTotal.Minor+deliveryCost.Minor+customFee.Minor, currency),
+		DeliveryCost: NewMoney(deliveryCost.Minor, currency),
+		GoodsTotal:   goodsTotal,
+		CustomFee:    NewMoney(customFee.Minor, currency),
+	}
+}
+
+// UnmarshalJSON дополнительно проставляет валюту платежа суммам заказа
+func (order *Order) UnmarshalJSON(data []byte) error {
+	type plain Order
+	err := json.Unmarshal(data, (*plain)(order))
+	if err != nil {
+		return err
+	}
+	order.setCurrency()
+	return nil
 }
 
 type dbRow struct {
@@ -67,6 +116,7 @@ type dbRow struct {
 	SmID              int
 	DateCreated       time.Time
 	OofShard          string
+	EventTime         sql.NullTime
 	DeliveryName      sql.NullString
 	DeliveryPhone     sql.NullString
 	DeliveryZip       sql.NullString
@@ -78,47 +128,131 @@ type dbRow struct {
 	RequestID         sql.NullString
 	Currency          sql.NullString
 	Provider          sql.NullString
-	Amount            sql.NullFloat64
+	Amount            Money
 	PaymentDt         sql.NullTime
 	Bank              sql.NullString
-	DeliveryCost      sql.NullFloat64
-	GoodsTotal        sql.NullFloat64
-	CustomFee         sql.NullFloat64
+	DeliveryCost      Money
+	GoodsTotal        Money
+	CustomFee         Money
 	ChrtID            sql.NullInt64
 	ItemName          sql.NullString
 	ItemSize          sql.NullString
 	NmID              sql.NullInt64
 	Brand             sql.NullString
 	ItemTrackNumber   sql.NullString
-	Price             sql.NullFloat64
+	Price             Money
 	Sale              sql.NullInt64
-	ItemTotalPrice    sql.NullFloat64
+	ItemTotalPrice    Money
 	Rid               sql.NullString
 	Status            sql.NullInt64
 }
 
-func (order *Order) ValidateMessageData() (bool, error) {
-	log.Printf("Валидация заказа с id == %v", order.OrderUID)
+// order, delivery, payment и item собирают части заказа из строки orderRowsQuery с LEFT JOIN,
+// поэтому NULL колонки читаются как пустые значения
+func (r dbRow) order() *Order {
+	return &Order{
+		OrderUID:          r.OrderUID,
+		TrackNumber:       r.TrackNumber,
+		Entry:             r.Entry,
+		Delivery:          r.delivery(),
+		Payment:           r.payment(),
+		Items:             []Item{},
+		Locale:            r.Locale,
+		InternalSignature: nullStringOrEmpty(r.InternalSignature),
+		CustomerID:        r.CustomerID,
+		DeliveryService:   r.DeliveryService,
+		Shardkey:          r.Shardkey,
+		SmID:              r.SmID,
+		DateCreated:       r.DateCreated.Format(time.RFC3339),
+		OofShard:          r.OofShard,
+		EventTime:         r.EventTime.Time,
+	}
+}
 
-	ok, err := validateMessageDataMainBody(order)
-	if !ok {
-		return false, err
+func (r dbRow) delivery() Delivery {
+	return Delivery{
+		Name:    nullStringOrEmpty(r.DeliveryName),
+		Phone:   nullStringOrEmpty(r.DeliveryPhone),
+		Zip:     nullStringOrEmpty(r.DeliveryZip),
+		City:    nullStringOrEmpty(r.DeliveryCity),
+		Address: nullStringOrEmpty(r.DeliveryAddress),
+		Region:  nullStringOrEmpty(r.DeliveryRegion),
+		Email:   nullStringOrEmpty(r.DeliveryEmail),
 	}
+}
 
-	ok, err = validateMessageDataDelivery(order)
-	if !ok {
-		return false, fmt.Errorf("ошибка валидации delivery: %w", err)
+func (r dbRow) payment() Payment {
+	payment := Payment{
+		Transaction:  nullStringOrEmpty(r.Transaction),
+		RequestID:    nullStringOrEmpty(r.RequestID),
+		Currency:     nullStringOrEmpty(r.Currency),
+		Provider:     nullStringOrEmpty(r.Provider),
+		Amount:       r.Amount,
+		Bank:         nullStringOrEmpty(r.Bank),
+		DeliveryCost: r.DeliveryCost,
+		GoodsTotal:   r.GoodsTotal,
+		CustomFee:    r.CustomFee,
 	}
+	// без платежа время NULL, а Unix() нулевого time.Time - не 0
+	if r.PaymentDt.Valid {
+		payment.PaymentDt = r.PaymentDt.Time.Unix()
+	}
+	return payment
+}
 
-	ok, err = validateMessageDataPayment(order)
-	if !ok {
-		return false, fmt.Errorf("ошибка валидации payment: %w", err)
+func (r dbRow) item() Item {
+	return Item{
+		ChrtID:      int(r.ChrtID.Int64),
+		TrackNumber: nullStringOrEmpty(r.ItemTrackNumber),
+		Price:       r.Price,
+		Rid:         nullStringOrEmpty(r.Rid),
+		Name:        nullStringOrEmpty(r.ItemName),
+		Sale:        int(nullInt64OrZero(r.Sale)),
+		Size:        nullStringOrEmpty(r.ItemSize),
+		TotalPrice:  r.ItemTotalPrice,
+		NmID:        int(r.NmID.Int64),
+		Brand:       nullStringOrEmpty(r.Brand),
+		Status:      int(r.Status.Int64),
 	}
+}
+
+// rowValues возвращают значения колонок в порядке INSERT из queueOrder
+func (d Delivery) rowValues(orderUID string) []any {
+	return []any{orderUID, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email}
+}
 
-	ok, err = validateMessageDataItems(order)
-	if !ok {
-		return false, fmt.Errorf("ошибка валидации items: %w", err)
+func (p Payment) rowValues(orderUID string) []any {
+	return []any{
+		orderUID, p.Transaction, p.RequestID, p.Currency, p.Provider,
+		p.Amount, time.Unix(p.PaymentDt, 0), p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee,
 	}
+}
+
+// itemRowValues - колонки справочника items, orderItemRowValues - позиции заказа в order_items
+func (item Item) itemRowValues() []any {
+	return []any{item.ChrtID, item.Name, item.Size, item.NmID, item.Brand}
+}
+
+// position - индекс товара в сообщении, по нему товары читаются в том же порядке
+func (item Item) orderItemRowValues(orderUID string, position int) []any {
+	return []any{
+		orderUID, item.ChrtID, item.TrackNumber,
+		item.Price, item.Sale, item.TotalPrice,
+		item.Rid, item.Status, position,
+	}
+}
+
+// ValidateMessageData проверяет все поля заказа по встроенным справочникам и возвращает все найденные нарушения
+func (order *Order) ValidateMessageData() ValidationResult {
+	return order.validateFields(builtinRules)
+}
+
+func (order *Order) validateFields(rules *referenceRules) ValidationResult {
+	var result ValidationResult
+	validateMessageDataMainBody(order, &result)
+	order.Delivery.validate("delivery", rules, &result)
+	order.Payment.validate("payment", rules, &result)
+	validateMessageDataItems(order, &result)
 
-	return true, nil
+	return result
 }
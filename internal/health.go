@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+// WorkerProgress считает сообщения в обработке и время последнего продвижения пула воркеров
+type WorkerProgress struct {
+	inFlight     atomic.Int64
+	lastProgress atomic.Int64
+}
+
+func NewWorkerProgress() *WorkerProgress {
+	p := &WorkerProgress{}
+	p.lastProgress.Store(time.Now().UnixNano())
+	return p
+}
+
+func (p *WorkerProgress) received() {
+	if p == nil {
+		return
+	}
+	// простаивавший пул не считается зависшим с момента последнего сообщения
+	if p.inFlight.Add(1) == 1 {
+		p.lastProgress.Store(time.Now().UnixNano())
+	}
+}
+
+func (p *WorkerProgress) completed() {
+	if p == nil {
+		return
+	}
+	p.inFlight.Add(-1)
+	p.lastProgress.Store(time.Now().UnixNano())
+}
+
+func (p *WorkerProgress) InFlight() int64 {
+	return p.inFlight.Load()
+}
+
+func (p *WorkerProgress) LastProgress() time.Time {
+	return time.Unix(0, p.lastProgress.Load())
+}
+
+// WarmupProgress отслеживает фоновый прогрев кеша для проверки готовности и метрик
+type WarmupProgress struct {
+	orders     atomic.Int64
+	startedAt  atomic.Int64
+	finishedAt atomic.Int64
+
+	mu  sync.Mutex
+	err error
+}
+
+func NewWarmupProgress() *WarmupProgress {
+	return &WarmupProgress{}
+}
+
+func (p *WarmupProgress) start() {
+	p.startedAt.Store(time.Now().UnixNano())
+	cacheWarmupDone.Set(0)
+}
+
+// loaded учитывает очередной загруженный заказ и возвращает число загруженных
+func (p *WarmupProgress) loaded() int64 {
+	cacheWarmupOrders.Inc()
+	return p.orders.Add(1)
+}
+
+func (p *WarmupProgress) finish(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+	p.finishedAt.Store(time.Now().UnixNano())
+	cacheWarmupDone.Set(1)
+}
+
+func (p *WarmupProgress) Loaded() int64 {
+	return p.orders.Load()
+}
+
+// Done возвращает true и после прогрева, прерванного ошибкой
+func (p *WarmupProgress) Done() bool {
+	return p.finishedAt.Load() != 0
+}
+
+func (p *WarmupProgress) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Duration - длительность прогрева или время с его начала, если он еще идет
+func (p *WarmupProgress) Duration() time.Duration {
+	started := p.startedAt.Load()
+	if started == 0 {
+		return 0
+	}
+	finished := p.finishedAt.Load()
+	if finished == 0 {
+		finished = time.Now().UnixNano()
+	}
+	return time.Duration(finished - started)
+}
+
+type HealthCheck struct {
+	Status    string         `json:"status"`
+	LatencyMs int64          `json:"latency_ms,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+type Health struct {
+	DB             *sql.DB
+	Brokers        []string
+	Cache          Cache
+	Progress       *WorkerProgress
+	Warmup         *WarmupProgress
+	StallThreshold time.Duration
+	CheckTimeout   time.Duration
+}
+
+// Ready проверяет зависимости параллельно, каждую со своим таймаутом
+func (h *Health) Ready(ctx context.Context) HealthReport {
+	checks := map[string]func(context.Context) HealthCheck{
+		"postgres": h.checkPostgres,
+		"kafka":    h.checkKafka,
+		"cache":    h.checkCache,
+		"worker":   h.checkWorker,
+	}
+
+	report := HealthReport{Status: HealthStatusOK, Checks: make(map[string]HealthCheck, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) HealthCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, h.CheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			result := check(checkCtx)
+			result.LatencyMs = time.Since(start).Milliseconds()
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != HealthStatusOK {
+				report.Status = HealthStatusUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (h *Health) checkPostgres(ctx context.Context) HealthCheck {
+	err := h.DB.PingContext(ctx)
+	if err != nil {
+		return unavailable(err)
+	}
+
+	stats := h.DB.Stats()
+	return HealthCheck{Status: HealthStatusOK, Details: map[string]any{
+		"open_connections": stats.OpenConnections,
+		"in_use":           stats.InUse,
+	}}
+}
+
+// kafka.Reader не сообщает о состоянии соединения, поэтому проверяется доступность хотя бы одного брокера
+func (h *Health) checkKafka(ctx context.Context) HealthCheck {
+	var errs []error
+	for _, broker := range h.Brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", broker, err))
+			continue
+		}
+		conn.Close()
+		return HealthCheck{Status: HealthStatusOK, Details: map[string]any{"broker": broker}}
+	}
+
+	return unavailable(errors.Join(errs...))
+}
+
+// checkCache не снимает готовность во время прогрева: промахи кеша читаются из бд,
+// а прогрев только показывает прогресс
+func (h *Health) checkCache(_ context.Context) HealthCheck {
+	details := map[string]any{
+		"size":           h.Cache.Len(),
+		"warm":           h.Warmup.Done(),
+		"warmup_orders":  h.Warmup.Loaded(),
+		"warmup_seconds": h.Warmup.Duration().Seconds(),
+	}
+	if err := h.Warmup.Err(); err != nil {
+		details["warmup_error"] = err.Error()
+	}
+	return HealthCheck{Status: HealthStatusOK, Details: details}
+}
+
+func (h *Health) checkWorker(_ context.Context) HealthCheck {
+	inFlight := h.Progress.InFlight()
+	last := h.Progress.LastProgress()
+	details := map[string]any{
+		"in_flight":     inFlight,
+		"last_progress": last.UTC().Format(time.RFC3339),
+	}
+
+	if inFlight > 0 && time.Since(last) > h.StallThreshold {
+		return HealthCheck{
+			Status:  HealthStatusUnavailable,
+			Error:   fmt.Sprintf("воркеры не продвигаются дольше %v", h.StallThreshold),
+			Details: details,
+		}
+	}
+	return HealthCheck{Status: HealthStatusOK, Details: details}
+}
+
+func unavailable(err error) HealthCheck {
+	return HealthCheck{Status: HealthStatusUnavailable, Error: err.Error()}
+}
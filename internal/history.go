@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	HistoryKindCreated   = "created"
+	HistoryKindUpdated   = "updated"
+	HistoryKindUnchanged = "unchanged"
+)
+
+type OrderEvent struct {
+	Order     Order
+	Topic     string
+	Partition int
+	Offset    int64
+}
+
+type OrderHistoryEntry struct {
+	OrderUID              string                 `json:"order_uid"`
+	EventTime             time.Time              `json:"event_time"`
+	KafkaTopic            string                 `json:"kafka_topic"`
+	KafkaPartition        int                    `json:"kafka_partition"`
+	KafkaOffset           int64                  `json:"kafka_offset"`
+	Kind                  string                 `json:"kind"`
+	Applied               bool                   `json:"applied"`
+	ChangedFields         []string               `json:"changed_fields"`
+	ItemStatusTransitions []ItemStatusTransition `json:"item_status_transitions"`
+	RecordedAt            time.Time              `json:"recorded_at"`
+}
+
+// From == nil означает, что товар появился в заказе, To == nil - что товар из заказа удален
+type ItemStatusTransition struct {
+	ChrtID int    `json:"chrt_id"`
+	Rid    string `json:"rid"`
+	From   *int   `json:"from"`
+	To     *int   `json:"to"`
+}
+
+func newHistoryEntry(event OrderEvent, prev *Order) OrderHistoryEntry {
+	kind, changed, transitions := diffOrders(prev, event.Order)
+
+	return OrderHistoryEntry{
+		OrderUID:              event.Order.OrderUID,
+		EventTime:             event.Order.EventTime,
+		KafkaTopic:            event.Topic,
+		KafkaPartition:        event.Partition,
+		KafkaOffset:           event.Offset,
+		Kind:                  kind,
+		ChangedFields:         changed,
+		ItemStatusTransitions: transitions,
+	}
+}
+
+// diffOrders возвращает пути измененных полей (delivery.address, items[9934930/ab42].price)
+// и переходы статусов товаров, товар определяется парой chrt_id/rid
+func diffOrders(prev *Order, next Order) (string, []string, []ItemStatusTransition) {
+	changed := []string{}
+	transitions := []ItemStatusTransition{}
+
+	if prev == nil {
+		for _, item := range next.Items {
+			to := item.Status
+			transitions = append(transitions, ItemStatusTransition{ChrtID: item.ChrtID, Rid: item.Rid, To: &to})
+		}
+		return HistoryKindCreated, changed, transitions
+	}
+
+	prevFields := flattenOrder(*prev)
+	nextFields := flattenOrder(next)
+	for path := range unionKeys(prevFields, nextFields) {
+		if !reflect.DeepEqual(prevFields[path], nextFields[path]) {
+			changed = append(changed, path)
+		}
+	}
+
+	type itemKey struct {
+		chrtID int
+		rid    string
+	}
+	prevItems := make(map[itemKey]int)
+	for i, item := range prev.Items {
+		prevItems[itemKey{item.ChrtID, item.Rid}] = i
+	}
+	nextItems := make(map[itemKey]int)
+	for i, item := range next.Items {
+		nextItems[itemKey{item.ChrtID, item.Rid}] = i
+	}
+
+	for _, item := range next.Items {
+		key := itemKey{item.ChrtID, item.Rid}
+		to := item.Status
+		i, ok := prevItems[key]
+		if !ok {
+			changed = append(changed, itemPath(item.ChrtID, item.Rid))
+			transitions = append(transitions, ItemStatusTransition{ChrtID: item.ChrtID, Rid: item.Rid, To: &to})
+			continue
+		}
+
+		prevItem := prev.Items[i]
+		prevItemFields := flattenJSON(prevItem)
+		nextItemFields := flattenJSON(item)
+		for path := range unionKeys(prevItemFields, nextItemFields) {
+			if !reflect.DeepEqual(prevItemFields[path], nextItemFields[path]) {
+				changed = append(changed, itemPath(item.ChrtID, item.Rid)+"."+path)
+			}
+		}
+		if prevItem.Status != item.Status {
+			from := prevItem.Status
+			transitions = append(transitions, ItemStatusTransition{ChrtID: item.ChrtID, Rid: item.Rid, From: &from, To: &to})
+		}
+	}
+
+	for _, item := range prev.Items {
+		if _, ok := nextItems[itemKey{item.ChrtID, item.Rid}]; ok {
+			continue
+		}
+		from := item.Status
+		changed = append(changed, itemPath(item.ChrtID, item.Rid))
+		transitions = append(transitions, ItemStatusTransition{ChrtID: item.ChrtID, Rid: item.Rid, From: &from})
+	}
+
+	sort.Strings(changed)
+
+	if len(changed) == 0 {
+		return HistoryKindUnchanged, changed, transitions
+	}
+	return HistoryKindUpdated, changed, transitions
+}
+
+func itemPath(chrtID int, rid string) string {
+	return fmt.Sprintf("items[%d/%s]", chrtID, rid)
+}
+
+// товары сравниваются отдельно по chrt_id/rid, а не по индексу в массиве
+func flattenOrder(order Order) map[string]any {
+	order.Items = nil
+	fields := flattenJSON(order)
+	delete(fields, "items")
+	return fields
+}
+
+func flattenJSON(v any) map[string]any {
+	fields := make(map[string]any)
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	var decoded map[string]any
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		return fields
+	}
+
+	flattenInto(fields, "", decoded)
+	return fields
+}
+
+func flattenInto(fields map[string]any, prefix string, value map[string]any) {
+	for key, v := range value {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		nested, ok := v.(map[string]any)
+		if ok {
+			flattenInto(fields, path, nested)
+			continue
+		}
+		fields[path] = v
+	}
+}
+
+func unionKeys(a, b map[string]any) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a))
+	for key := range a {
+		keys[key] = struct{}{}
+	}
+	for key := range b {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+// queueHistory ставится в пачку после upsert заказа, поэтому applied показывает,
+// приняла ли бд это событие или оно оказалось старше сохраненной версии
+func queueHistory(batch *pgx.Batch, entry OrderHistoryEntry) error {
+	changed, err := json.Marshal(entry.ChangedFields)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации измененных полей заказа %v: %w. ", entry.OrderUID, err)
+	}
+	transitions, err := json.Marshal(entry.ItemStatusTransitions)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации статусов товаров заказа %v: %w. ", entry.OrderUID, err)
+	}
+
+	batch.Queue(`
+		INSERT INTO order_history (
+			order_uid, event_time, kafka_topic, kafka_partition, kafka_offset,
+			kind, applied, changed_fields, item_status_transitions
+		) VALUES (
+			$1, $2, $3, $4, $5, $6,
+			COALESCE((SELECT event_time FROM orders WHERE order_uid = $1) = $2, false),
+			$7, $8
+		)
+		ON CONFLICT (kafka_topic, kafka_partition, kafka_offset) DO NOTHING
+	`,
+		entry.OrderUID, entry.EventTime, entry.KafkaTopic, entry.KafkaPartition, entry.KafkaOffset,
+		entry.Kind, string(changed), string(transitions),
+	)
+
+	return nil
+}
+
+func getOrderHistoryFromDB(ctx context.Context, db *sql.DB, orderUID string) ([]OrderHistoryEntry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			order_uid, event_time, kafka_topic, kafka_partition, kafka_offset,
+			kind, applied, changed_fields, item_status_transitions, recorded_at
+		FROM order_history
+		WHERE order_uid = $1
+		ORDER BY event_time, id
+	`, orderUID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выполнения запроса: %w. ", err)
+	}
+	defer rows.Close()
+
+	history := []OrderHistoryEntry{}
+	for rows.Next() {
+		var entry OrderHistoryEntry
+		var changed, transitions []byte
+		err := rows.Scan(
+			&entry.OrderUID, &entry.EventTime, &entry.KafkaTopic, &entry.KafkaPartition, &entry.KafkaOffset,
+			&entry.Kind, &entry.Applied, &changed, &transitions, &entry.RecordedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования строки: %w. ", err)
+		}
+
+		err = json.Unmarshal(changed, &entry.ChangedFields)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка разбора измененных полей: %w. ", err)
+		}
+		err = json.Unmarshal(transitions, &entry.ItemStatusTransitions)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка разбора статусов товаров: %w. ", err)
+		}
+
+		history = append(history, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка при итерации по строкам: %w", err)
+	}
+
+	return history, nil
+}
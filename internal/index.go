@@ -0,0 +1,80 @@
+package internal
+
+import "fmt"
+
+// OrderIndex - вторичный ключ заказа, по которому заказы ищутся без order_uid
+type OrderIndex string
+
+const (
+	OrderIndexTrackNumber OrderIndex = "track_number"
+	OrderIndexTransaction OrderIndex = "transaction"
+	OrderIndexCustomerID  OrderIndex = "customer_id"
+)
+
+var orderIndexes = []OrderIndex{OrderIndexTrackNumber, OrderIndexTransaction, OrderIndexCustomerID}
+
+// value возвращает значение ключа у заказа; пустое значение не индексируется
+func (index OrderIndex) value(order Order) string {
+	switch index {
+	case OrderIndexTrackNumber:
+		return order.TrackNumber
+	case OrderIndexTransaction:
+		return order.Payment.Transaction
+	case OrderIndexCustomerID:
+		return order.CustomerID
+	}
+	return ""
+}
+
+// column - колонка ключа в orderRowsQuery
+func (index OrderIndex) column() (string, error) {
+	switch index {
+	case OrderIndexTrackNumber:
+		return "o.track_number", nil
+	case OrderIndexTransaction:
+		return "p.transaction", nil
+	case OrderIndexCustomerID:
+		return "o.customer_id", nil
+	}
+	return "", fmt.Errorf("неизвестный индекс заказов %q", index)
+}
+
+// cacheIndex связывает значение ключа с order_uid заказов в кеше. complete отмечает значения,
+// для которых в кеше лежат все заказы из бд: такие значения отвечают без запроса в бд.
+// Отметка снимается, как только заказ значения покидает кеш; значение без заказов удаляется
+// вместе с отметкой, поэтому пустые ответы бд в кеше не копятся
+type cacheIndex struct {
+	orders   map[string]map[string]struct{}
+	complete map[string]bool
+}
+
+func newCacheIndex() *cacheIndex {
+	return &cacheIndex{
+		orders:   make(map[string]map[string]struct{}),
+		complete: make(map[string]bool),
+	}
+}
+
+func (i *cacheIndex) add(value, orderUID string) {
+	if value == "" {
+		return
+	}
+	uids, ok := i.orders[value]
+	if !ok {
+		uids = make(map[string]struct{})
+		i.orders[value] = uids
+	}
+	uids[orderUID] = struct{}{}
+}
+
+func (i *cacheIndex) remove(value, orderUID string) {
+	uids, ok := i.orders[value]
+	if !ok {
+		return
+	}
+	delete(uids, orderUID)
+	delete(i.complete, value)
+	if len(uids) == 0 {
+		delete(i.orders, value)
+	}
+}
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// единые имена полей, по которым записи одного заказа или запроса собираются в системе логов
+const (
+	LogKeyOrderUID  = "order_uid"
+	LogKeyTopic     = "topic"
+	LogKeyPartition = "partition"
+	LogKeyOffset    = "offset"
+	LogKeyStage     = "stage"
+	LogKeyRequestID = "request_id"
+	LogKeyTraceID   = "trace_id"
+	LogKeyError     = "error"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// значения этих полей маскируются в любой группе, даже если их передали в лог напрямую
+var piiLogKeys = map[string]bool{
+	"name":    true,
+	"phone":   true,
+	"email":   true,
+	"address": true,
+	"city":    true,
+	"zip":     true,
+	"region":  true,
+}
+
+// NewLogger создает логгер с уровнем и форматом из конфигурации, маскирующий персональные данные
+func NewLogger(w io.Writer, cfg LogConfig) (*slog.Logger, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(cfg.Level))
+	if err != nil {
+		return nil, fmt.Errorf("некорректный уровень логирования %q: %w", cfg.Level, err)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: maskPIIAttr,
+	}
+
+	switch cfg.Format {
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("неизвестный формат логов %q", cfg.Format)
+	}
+}
+
+type loggerKey struct{}
+
+// WithLogger сохраняет в ctx логгер с полями текущего сообщения или запроса
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger возвращает логгер из ctx, а если его там нет - логгер по умолчанию
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// withMessage добавляет к логгеру ctx координаты сообщения kafka.
+// Продюсер использует order_uid как ключ сообщения, поэтому он известен еще до десериализации
+func withMessage(ctx context.Context, msg kafka.Message) context.Context {
+	logger := Logger(ctx).With(
+		slog.String(LogKeyTopic, msg.Topic),
+		slog.Int(LogKeyPartition, msg.Partition),
+		slog.Int64(LogKeyOffset, msg.Offset),
+	)
+	if len(msg.Key) > 0 {
+		logger = logger.With(slog.String(LogKeyOrderUID, string(msg.Key)))
+	}
+	return WithLogger(ctx, logger)
+}
+
+// RequestLogger присваивает запросу request_id (берется из X-Request-ID, если клиент его передал),
+// кладет логгер с ним в контекст запроса и пишет одну запись о каждом обработанном запросе
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		logger := Logger(c.Request.Context()).With(slog.String(LogKeyRequestID, requestID))
+		ctx := withTraceID(WithLogger(c.Request.Context(), logger))
+		logger = Logger(ctx)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		logger.Log(c.Request.Context(), level, "http запрос",
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("latency", time.Since(start)),
+		)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// LogValue не пускает в лог состав заказа и данные получателя
+func (order Order) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String(LogKeyOrderUID, order.OrderUID),
+		slog.String("track_number", order.TrackNumber),
+		slog.String("customer_id", order.CustomerID),
+		slog.Int("items", len(order.Items)),
+		slog.Time("event_time", order.EventTime),
+	)
+}
+
+func maskPIIAttr(_ []string, a slog.Attr) slog.Attr {
+	if !piiLogKeys[strings.ToLower(a.Key)] || a.Value.Kind() != slog.KindString {
+		return a
+	}
+	return slog.String(a.Key, maskPII(strings.ToLower(a.Key), a.Value.String()))
+}
+
+// maskPII оставляет от значения ровно столько, чтобы его можно было сверить с обращением клиента
+func maskPII(key, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	switch key {
+	case "phone":
+		// видны только две последние цифры номера
+		digits := 0
+		for _, r := range value {
+			if r >= '0' && r <= '9' {
+				digits++
+			}
+		}
+		var b strings.Builder
+		for _, r := range value {
+			if r >= '0' && r <= '9' {
+				digits--
+				if digits >= 2 {
+					r = '*'
+				}
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	case "email":
+		local, domain, ok := strings.Cut(value, "@")
+		if !ok {
+			return maskPII("", value)
+		}
+		return maskPII("", local) + "@" + domain
+	default:
+		first, _ := utf8.DecodeRuneInString(value)
+		return string(first) + "***"
+	}
+}
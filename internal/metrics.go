@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+const metricsNamespace = "l0"
+
+var (
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_consumed_total",
+		Help:      "Сообщения, прочитанные из kafka.",
+	}, []string{"topic", "partition"})
+
+	messagesProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_processed_total",
+		Help:      "Сообщения, заказы из которых сохранены в бд.",
+	})
+
+	messagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_failed_total",
+		Help:      "Сообщения, которые не удалось обработать, по этапу обработки.",
+	}, []string{"stage"})
+
+	consistencyViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "consistency_violations_total",
+		Help:      "Нарушения правил согласованности заказа по правилу и режиму (reject или warn).",
+	}, []string{"rule", "mode"})
+
+	rulesReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "validation_rules_reloads_total",
+		Help:      "Применение измененных справочников валидации и ошибки их загрузки.",
+	}, []string{"result"})
+
+	messagesDeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_dead_lettered_total",
+		Help:      "Сообщения, отправленные в dead-letter топик, по этапу обработки.",
+	}, []string{"stage"})
+
+	messagesDeadLetterFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_dead_letter_failed_total",
+		Help:      "Неудачные попытки записи в dead-letter топик; воркер повторяет запись, пока она не удастся.",
+	}, []string{"stage"})
+
+	messageRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "message_retries_total",
+		Help:      "Повторные попытки сохранения после временных ошибок бд.",
+	})
+
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "kafka_consumer_lag",
+		Help:      "Отставание от high watermark партиции на момент чтения последнего сообщения.",
+	}, []string{"topic", "partition"})
+
+	saveDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "save_orders_duration_seconds",
+		Help:      "Время сохранения пачки заказов в бд.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	saveBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "save_orders_batch_size",
+		Help:      "Количество заказов в сохраняемой пачке.",
+		Buckets:   []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000},
+	})
+
+	cacheWarmupOrders = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_warmup_orders_total",
+		Help:      "Заказы, загруженные в кеш при прогреве.",
+	})
+
+	cacheWarmupDone = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_warmup_done",
+		Help:      "1, если прогрев кеша завершен (в том числе с ошибкой), иначе 0.",
+	})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Время обработки http запросов по маршруту и статусу ответа.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// RegisterMetrics регистрирует метрики, которые снимаются с объектов при каждом запросе /metrics
+func RegisterMetrics(db *sql.DB, cache Cache, reader *kafka.Reader) {
+	prometheus.MustRegister(
+		collectors.NewDBStatsCollector(db, "l0"),
+		newCacheCollector(cache),
+		newReaderCollector(reader),
+	)
+}
+
+func observeConsumed(msg kafka.Message) {
+	partition := strconv.Itoa(msg.Partition)
+	messagesConsumed.WithLabelValues(msg.Topic, partition).Inc()
+	if msg.HighWaterMark > 0 {
+		consumerLag.WithLabelValues(msg.Topic, partition).Set(float64(msg.HighWaterMark - msg.Offset - 1))
+	}
+}
+
+func observeSave(start time.Time, batchSize int, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	saveDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	saveBatchSize.Observe(float64(batchSize))
+}
+
+// HTTPMetrics - middleware gin; маршрут берется из шаблона (/order/:ouid), чтобы не плодить метки
+func HTTPMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+type cacheCollector struct {
+	cache       Cache
+	size        *prometheus.Desc
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	evictions   *prometheus.Desc
+	expirations *prometheus.Desc
+}
+
+func newCacheCollector(cache Cache) *cacheCollector {
+	return &cacheCollector{
+		cache:       cache,
+		size:        prometheus.NewDesc("l0_cache_size", "Количество заказов в кеше.", nil, nil),
+		hits:        prometheus.NewDesc("l0_cache_hits_total", "Попадания в кеш при чтении заказа.", nil, nil),
+		misses:      prometheus.NewDesc("l0_cache_misses_total", "Промахи кеша при чтении заказа.", nil, nil),
+		evictions:   prometheus.NewDesc("l0_cache_evictions_total", "Заказы, вытесненные из кеша при превышении размера.", nil, nil),
+		expirations: prometheus.NewDesc("l0_cache_expirations_total", "Заказы, удаленные из кеша по истечении ttl.", nil, nil),
+	}
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.size
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expirations
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.cache.Stats()
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(c.cache.Len()))
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(stats.Expirations))
+}
+
+// readerCollector накапливает счетчики kafka.Reader: Stats() возвращает их прирост с прошлого вызова
+type readerCollector struct {
+	reader *kafka.Reader
+
+	mu         sync.Mutex
+	errors     int64
+	timeouts   int64
+	rebalances int64
+
+	errorsDesc     *prometheus.Desc
+	timeoutsDesc   *prometheus.Desc
+	rebalancesDesc *prometheus.Desc
+	queueDesc      *prometheus.Desc
+}
+
+func newReaderCollector(reader *kafka.Reader) *readerCollector {
+	return &readerCollector{
+		reader:         reader,
+		errorsDesc:     prometheus.NewDesc("l0_kafka_reader_errors_total", "Ошибки чтения kafka.", nil, nil),
+		timeoutsDesc:   prometheus.NewDesc("l0_kafka_reader_timeouts_total", "Таймауты чтения kafka.", nil, nil),
+		rebalancesDesc: prometheus.NewDesc("l0_kafka_reader_rebalances_total", "Ребалансировки группы потребителей.", nil, nil),
+		queueDesc:      prometheus.NewDesc("l0_kafka_reader_queue_length", "Сообщения во внутренней очереди kafka.Reader.", nil, nil),
+	}
+}
+
+func (c *readerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.errorsDesc
+	ch <- c.timeoutsDesc
+	ch <- c.rebalancesDesc
+	ch <- c.queueDesc
+}
+
+func (c *readerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.reader.Stats()
+	c.errors += stats.Errors
+	c.timeouts += stats.Timeouts
+	c.rebalances += stats.Rebalances
+
+	ch <- prometheus.MustNewConstMetric(c.errorsDesc, prometheus.CounterValue, float64(c.errors))
+	ch <- prometheus.MustNewConstMetric(c.timeoutsDesc, prometheus.CounterValue, float64(c.timeouts))
+	ch <- prometheus.MustNewConstMetric(c.rebalancesDesc, prometheus.CounterValue, float64(c.rebalances))
+	ch <- prometheus.MustNewConstMetric(c.queueDesc, prometheus.GaugeValue, float64(stats.QueueLength))
+}
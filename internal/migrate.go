@@ -0,0 +1,411 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ключ advisory lock, чтобы несколько экземпляров сервиса не применяли миграции одновременно
+const migrationLockID = 7305186001
+
+// migrationLockPollInterval - пауза между попытками взять advisory lock, занятый другим экземпляром
+const migrationLockPollInterval = 500 * time.Millisecond
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// noTransactionMarker в первой строке файла выполняет миграцию вне транзакции, по одной команде.
+// Так работает CREATE INDEX CONCURRENTLY, который строит индекс, не блокируя запись в таблицу.
+// Команды такой миграции разделяются ';' в конце строки и должны быть безопасны для повтора:
+// после сбоя часть из них уже выполнена, а версия еще не записана
+const noTransactionMarker = "-- migrate:no-transaction"
+
+var ErrSchemaAhead = errors.New("схема бд новее, чем поддерживает сервис")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// миграция применена в бд, но отсутствует в этой версии сервиса
+	Unknown bool
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения каталога миграций: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("некорректная версия миграции %v: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения миграции %v: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("у миграции версии %v разные названия: %v и %v", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("у миграции %03d_%v нет up файла", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func (m *Migrator) LatestVersion() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up применяет все непримененные миграции, каждую в своей транзакции (кроме миграций с noTransactionMarker)
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkAhead(versions); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			err := execMigration(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("ошибка применения миграции %03d_%v: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down откатывает последнюю примененную миграцию
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	var reverted Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkAhead(versions); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("у миграции %03d_%v нет down файла", migration.Version, migration.Name)
+			}
+
+			err := execMigration(ctx, conn, migration.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("ошибка отката миграции %03d_%v: %w", migration.Version, migration.Name, err)
+			}
+			reverted = migration
+			return nil
+		}
+
+		return fmt.Errorf("нет примененных миграций")
+	})
+
+	return reverted, err
+}
+
+// Baseline отмечает миграции до version включительно примененными, не выполняя их.
+// Нужен для баз, созданных до появления schema_migrations
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+			_, err := conn.ExecContext(ctx, `
+				INSERT INTO schema_migrations (version, name) VALUES ($1, $2)
+				ON CONFLICT (version) DO NOTHING
+			`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("ошибка отметки миграции %03d_%v: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		known := make(map[int]bool, len(m.migrations))
+		for _, migration := range m.migrations {
+			known[migration.Version] = true
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if applied, ok := versions[migration.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = applied.AppliedAt
+			}
+			statuses = append(statuses, status)
+		}
+
+		for version, applied := range versions {
+			if !known[version] {
+				statuses = append(statuses, applied)
+			}
+		}
+		sort.Slice(statuses, func(i, j int) bool {
+			return statuses[i].Version < statuses[j].Version
+		})
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Pending возвращает непримененные миграции и ErrSchemaAhead, если в бд есть миграции новее сервиса
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	var pending []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkAhead(versions); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; !ok {
+				pending = append(pending, migration)
+			}
+		}
+		return nil
+	})
+
+	return pending, err
+}
+
+func (m *Migrator) checkAhead(versions map[int]MigrationStatus) error {
+	for version, status := range versions {
+		if version > m.LatestVersion() {
+			return fmt.Errorf("%w: применена миграция %03d_%v, последняя известная сервису - %03d", ErrSchemaAhead, version, status.Name, m.LatestVersion())
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения соединения из пула: %w", err)
+	}
+	defer conn.Close()
+
+	err = lockMigrations(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("ошибка блокировки миграций: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version integer PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// lockMigrations ждет advisory lock опросом pg_try_advisory_lock, а не блокирующим pg_advisory_lock:
+// ожидающий запрос держит снимок, а CREATE INDEX CONCURRENTLY экземпляра, который применяет
+// миграции, ждет завершения всех более старых снимков, и postgres прервал бы оба по deadlock
+func lockMigrations(ctx context.Context, conn *sql.Conn) error {
+	for waited := false; ; waited = true {
+		var locked bool
+		err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, migrationLockID).Scan(&locked)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+
+		if !waited {
+			Logger(ctx).Info("Миграции применяет другой экземпляр сервиса, ожидание блокировки")
+		}
+		if !sleepContext(ctx, migrationLockPollInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]MigrationStatus, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int]MigrationStatus)
+	for rows.Next() {
+		status := MigrationStatus{Applied: true, Unknown: true}
+		err := rows.Scan(&status.Version, &status.Name, &status.AppliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования строки: %w", err)
+		}
+		versions[status.Version] = status
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка при итерации по строкам: %w", err)
+	}
+
+	return versions, nil
+}
+
+// execMigration выполняет sql миграции и запрос record к schema_migrations в одной транзакции,
+// а миграцию с noTransactionMarker - по одной команде без транзакции, записывая версию последней
+func execMigration(ctx context.Context, conn *sql.Conn, body, record string, args ...any) error {
+	if !strings.HasPrefix(strings.TrimSpace(body), noTransactionMarker) {
+		return runInTx(ctx, conn, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, body)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, record, args...)
+			return err
+		})
+	}
+
+	// несколько команд в одном запросе postgres выполнил бы в неявной транзакции
+	for _, statement := range splitStatements(body) {
+		_, err := conn.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := conn.ExecContext(ctx, record, args...)
+	return err
+}
+
+// splitStatements делит sql на команды по ';' в конце строки, строки-комментарии пропускаются
+func splitStatements(body string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, current.String())
+			current.Reset()
+		}
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		statements = append(statements, current.String())
+	}
+	return statements
+}
+
+func runInTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
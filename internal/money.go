@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// MoneyScale - число знаков после запятой у сумм заказа, как у колонок numeric(10,2)
+const MoneyScale = 2
+
+// maxMoneyMinor - наибольшая сумма в минимальных единицах, которая помещается в numeric(10,2)
+const maxMoneyMinor = 99999999_99
+
+var moneyMinorPerUnit = big.NewRat(100, 1)
+
+// big.Rat.SetString строит точное значение числа, поэтому "1e100000000" из сообщения стоило бы сотни
+// мегабайт и секунды CPU. Перед разбором длина числа и его порядок ограничены с большим запасом
+// для numeric(10,2), а запись - десятичной, без дробей и шестнадцатеричных форм, которые SetString тоже принимает
+const (
+	maxDecimalLength   = 64
+	maxDecimalExponent = 64
+)
+
+var decimalPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE]([+-]?[0-9]+))?$`)
+
+// parseDecimal разбирает десятичное число JSON в точное значение
+func parseDecimal(s string) (*big.Rat, error) {
+	if len(s) > maxDecimalLength {
+		return nil, fmt.Errorf("число длиннее %d символов", maxDecimalLength)
+	}
+	match := decimalPattern.FindStringSubmatch(s)
+	if match == nil {
+		return nil, fmt.Errorf("некорректное число %q", s)
+	}
+	if match[3] != "" {
+		exponent, err := strconv.Atoi(match[3])
+		if err != nil || exponent > maxDecimalExponent || exponent < -maxDecimalExponent {
+			return nil, fmt.Errorf("порядок числа %q больше допустимого %d", s, maxDecimalExponent)
+		}
+	}
+
+	value, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("некорректное число %q", s)
+	}
+	return value, nil
+}
+
+// Money - сумма в минимальных единицах валюты (копейках, центах).
+// В JSON и в postgres сумма передается десятичным числом в основных единицах (18.17),
+// при разборе и записи значение не проходит через float, поэтому копейки не теряются.
+// Валюта в сообщении передается один раз в payment.currency и проставляется всем суммам заказа
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+func NewMoney(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: currency}
+}
+
+// ParseMoney разбирает десятичное число в основных единицах; больше MoneyScale знаков
+// после запятой - ошибка, а не повод округлять
+func ParseMoney(s, currency string) (Money, error) {
+	value, err := parseDecimal(s)
+	if err != nil {
+		return Money{}, fmt.Errorf("некорректная сумма: %w", err)
+	}
+
+	value.Mul(value, moneyMinorPerUnit)
+	if !value.IsInt() {
+		return Money{}, fmt.Errorf("сумма %v содержит больше %d знаков после запятой", s, MoneyScale)
+	}
+	if !value.Num().IsInt64() {
+		return Money{}, fmt.Errorf("сумма %v слишком большая", s)
+	}
+
+	return Money{Minor: value.Num().Int64(), Currency: currency}, nil
+}
+
+// String возвращает сумму в основных единицах ровно с MoneyScale знаками после запятой
+func (m Money) String() string {
+	sign := ""
+	minor := uint64(m.Minor)
+	if m.Minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	return fmt.Sprintf("%v%d.%02d", sign, minor/100, minor%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON принимает только JSON число, валюта суммы не меняется
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) == 0 || data[0] == '"' {
+		return fmt.Errorf("сумма должна быть числом, получено %s", data)
+	}
+
+	parsed, err := ParseMoney(string(data), m.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan читает numeric из database/sql: pgx отдает его строкой, NULL читается как 0
+func (m *Money) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*m = Money{Currency: m.Currency}
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int64:
+		*m = Money{Minor: v * 100, Currency: m.Currency}
+		return nil
+	default:
+		return fmt.Errorf("сумма не может быть прочитана из %T", src)
+	}
+
+	parsed, err := ParseMoney(s, m.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// NumericValue передает сумму в pgx как numeric с явным масштабом, без промежуточного float
+func (m Money) NumericValue() (pgtype.Numeric, error) {
+	return pgtype.Numeric{Int: big.NewInt(m.Minor), Exp: -MoneyScale, Valid: true}, nil
+}
+
+// JSONSchema описывает сумму в схеме заказа: число с шагом 0.01, а не объект Money
+func (Money) JSONSchema() *JSONSchema {
+	return &JSONSchema{Type: "number", MultipleOf: "0.01"}
+}
+
+// setCurrency проставляет валюту платежа всем суммам заказа
+func (order *Order) setCurrency() {
+	currency := order.Payment.Currency
+	order.Payment.Amount.Currency = currency
+	order.Payment.DeliveryCost.Currency = currency
+	order.Payment.GoodsTotal.Currency = currency
+	order.Payment.CustomFee.Currency = currency
+	for i := range order.Items {
+		order.Items[i].Price.Currency = currency
+		order.Items[i].TotalPrice.Currency = currency
+	}
+}
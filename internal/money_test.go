@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "18.17", want: 1817},
+		{in: "18", want: 1800},
+		{in: "18.1", want: 1810},
+		{in: "0.01", want: 1},
+		{in: "0", want: 0},
+		{in: "-5.5", want: -550},
+		{in: "-0.01", want: -1},
+		// лишние нули после запятой не меняют значения
+		{in: "1.100", want: 110},
+
+		{in: "1.817e1", want: 1817},
+		{in: "1817e-2", want: 1817},
+		{in: "1817E-2", want: 1817},
+		{in: "1e2", want: 10000},
+		{in: "1e+2", want: 10000},
+		{in: "-1.5e1", want: -1500},
+		{in: "1e-3", wantErr: true},
+
+		{in: "1.001", wantErr: true},
+		{in: "18.175", wantErr: true},
+		{in: "0.005", wantErr: true},
+
+		{in: "92233720368547758.07", want: math.MaxInt64},
+		{in: "-92233720368547758.08", want: math.MinInt64},
+		{in: "92233720368547758.08", wantErr: true},
+		{in: "-92233720368547758.09", wantErr: true},
+		{in: "1e30", wantErr: true},
+		{in: "1e64", wantErr: true},
+		{in: "1e65", wantErr: true},
+		{in: "1e100000000", wantErr: true},
+		{in: "1e-100000000", wantErr: true},
+		{in: "1" + strings.Repeat("0", maxDecimalLength), wantErr: true},
+
+		{in: "", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "+1", wantErr: true},
+		{in: ".5", wantErr: true},
+		{in: "1.", wantErr: true},
+		{in: "0x10", wantErr: true},
+		{in: "1/2", wantErr: true},
+		{in: "1_000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMoney(tt.in, "RUB")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseMoney(%q) = %v, want ошибку", tt.in, got.Minor)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMoney(%q) error = %v", tt.in, err)
+			}
+			if got != NewMoney(tt.want, "RUB") {
+				t.Errorf("ParseMoney(%q) = %+v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyMarshalJSON(t *testing.T) {
+	tests := []struct {
+		minor int64
+		want  string
+	}{
+		{minor: 1817, want: "18.17"},
+		{minor: 1800, want: "18.00"},
+		{minor: 5, want: "0.05"},
+		{minor: 0, want: "0.00"},
+		{minor: -550, want: "-5.50"},
+		{minor: -1, want: "-0.01"},
+		{minor: math.MaxInt64, want: "92233720368547758.07"},
+		{minor: math.MinInt64, want: "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			data, err := json.Marshal(NewMoney(tt.minor, "RUB"))
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Marshal(%v) = %s, want %v", tt.minor, data, tt.want)
+			}
+
+			// сумма, записанная в JSON, читается обратно без потерь
+			parsed := Money{Currency: "RUB"}
+			if err := json.Unmarshal(data, &parsed); err != nil {
+				t.Fatalf("Unmarshal(%s) error = %v", data, err)
+			}
+			if parsed.Minor != tt.minor {
+				t.Errorf("Unmarshal(%s) = %v, want %v", data, parsed.Minor, tt.minor)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "18.17", want: 1817},
+		{in: "null", want: 42},
+		{in: `"18.17"`, wantErr: true},
+		{in: "18.171", wantErr: true},
+		{in: "true", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			m := NewMoney(42, "USD")
+			err := m.UnmarshalJSON([]byte(tt.in))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("UnmarshalJSON(%s) = %+v, want ошибку", tt.in, m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UnmarshalJSON(%s) error = %v", tt.in, err)
+			}
+			// валюта суммы не приходит в JSON и не меняется
+			if m != NewMoney(tt.want, "USD") {
+				t.Errorf("UnmarshalJSON(%s) = %+v, want %v USD", tt.in, m, tt.want)
+			}
+		})
+	}
+}
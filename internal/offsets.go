@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// offsetTracker коммитит offset партиции только до последнего сообщения,
+// перед которым все сообщения этой партиции уже обработаны
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[string]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	mu sync.Mutex
+	// pending - прочитанные и еще не закоммиченные offset по возрастанию, без повторов
+	pending []int64
+	done    map[int64]kafka.Message
+	// lastTracked - последний прочитанный offset, -1 до первого сообщения
+	lastTracked int64
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[string]*partitionOffsets),
+	}
+}
+
+func (t *offsetTracker) partition(msg kafka.Message) *partitionOffsets {
+	key := fmt.Sprintf("%s/%d", msg.Topic, msg.Partition)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[key]
+	if !ok {
+		p = &partitionOffsets{done: make(map[int64]kafka.Message), lastTracked: -1}
+		t.partitions[key] = p
+	}
+	return p
+}
+
+// track вызывается в порядке чтения сообщений из топика. Offset не больше уже прочитанного означает,
+// что партиция читается заново с закоммиченного offset: после ребалансировки (kafka-go не сообщает
+// о смене поколения группы и назначенных партиций) или повторной доставки. Пропуски прежнего чтения
+// уже не закроются, поэтому состояние партиции сбрасывается; track возвращает true, если сброс был
+func (t *offsetTracker) track(msg kafka.Message) bool {
+	p := t.partition(msg)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	reset := p.lastTracked >= 0 && msg.Offset <= p.lastTracked
+	if reset {
+		p.pending = nil
+		clear(p.done)
+	}
+	p.pending = append(p.pending, msg.Offset)
+	p.lastTracked = msg.Offset
+	return reset
+}
+
+// partitionCommit - результат коммита одной партиции: последнее закоммиченное сообщение и ошибка коммита
+type partitionCommit struct {
+	msg kafka.Message
+	err error
+}
+
+// complete отмечает сообщения обработанными и для каждой партиции, где непрерывный префикс сдвинулся,
+// коммитит его последнее сообщение: пачка из многих сообщений одной партиции - один коммит.
+// Коммит выполняется под блокировкой партиции, чтобы более старый offset не перезаписал более новый
+func (t *offsetTracker) complete(ctx context.Context, msgs []kafka.Message, commit func(context.Context, ...kafka.Message) error) []partitionCommit {
+	var partitions []*partitionOffsets
+	byPartition := make(map[*partitionOffsets][]kafka.Message)
+	for _, msg := range msgs {
+		p := t.partition(msg)
+		if _, ok := byPartition[p]; !ok {
+			partitions = append(partitions, p)
+		}
+		byPartition[p] = append(byPartition[p], msg)
+	}
+
+	var commits []partitionCommit
+	for _, p := range partitions {
+		last, ok := p.complete(byPartition[p])
+		if ok {
+			commits = append(commits, partitionCommit{msg: last, err: commit(ctx, last)})
+		}
+		p.mu.Unlock()
+	}
+	return commits
+}
+
+// complete отмечает сообщения партиции и сдвигает непрерывный префикс; возвращает его последнее
+// сообщение, если префикс сдвинулся. Блокировка партиции остается захваченной до коммита
+func (p *partitionOffsets) complete(msgs []kafka.Message) (kafka.Message, bool) {
+	p.mu.Lock()
+
+	for _, msg := range msgs {
+		// сообщение прочитано до сброса партиции: его offset уже не ожидается
+		if _, ok := slices.BinarySearch(p.pending, msg.Offset); ok {
+			p.done[msg.Offset] = msg
+		}
+	}
+
+	var last kafka.Message
+	advanced := false
+	for len(p.pending) > 0 {
+		doneMsg, ok := p.done[p.pending[0]]
+		if !ok {
+			break
+		}
+		delete(p.done, p.pending[0])
+		p.pending = p.pending[1:]
+		last = doneMsg
+		advanced = true
+	}
+	return last, advanced
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// commitRecorder запоминает offset каждого коммита
+type commitRecorder struct {
+	offsets []int64
+}
+
+func (r *commitRecorder) commit(_ context.Context, msgs ...kafka.Message) error {
+	for _, msg := range msgs {
+		r.offsets = append(r.offsets, msg.Offset)
+	}
+	return nil
+}
+
+func testMessage(partition int, offset int64) kafka.Message {
+	return kafka.Message{Topic: "orders", Partition: partition, Offset: offset}
+}
+
+// сообщение, которое не завершается (например, ждет записи в dead-letter топик), держит коммиты
+// партиции, пока не завершится; после этого одним коммитом закрывается весь накопленный префикс
+func TestOffsetTrackerWaitsForIncompleteOffset(t *testing.T) {
+	ctx := context.Background()
+	tracker := newOffsetTracker()
+	var recorder commitRecorder
+
+	const later = 999
+	for offset := int64(0); offset <= later; offset++ {
+		tracker.track(testMessage(0, offset))
+	}
+	for offset := int64(1); offset <= later; offset++ {
+		tracker.complete(ctx, []kafka.Message{testMessage(0, offset)}, recorder.commit)
+	}
+
+	if len(recorder.offsets) != 0 {
+		t.Fatalf("коммиты до завершения offset 0: %v", recorder.offsets)
+	}
+	p := tracker.partition(testMessage(0, 0))
+	if len(p.done) != later {
+		t.Fatalf("ожидают коммита %v сообщений, want %v", len(p.done), later)
+	}
+
+	commits := tracker.complete(ctx, []kafka.Message{testMessage(0, 0)}, recorder.commit)
+	if len(commits) != 1 || commits[0].msg.Offset != later || len(recorder.offsets) != 1 {
+		t.Fatalf("коммиты после завершения offset 0: %+v (%v), want один коммит offset %v", commits, recorder.offsets, later)
+	}
+	if len(p.done) != 0 || len(p.pending) != 0 {
+		t.Errorf("после коммита остались done=%v, pending=%v", len(p.done), len(p.pending))
+	}
+}
+
+func testMessages(partition int, offsets ...int64) []kafka.Message {
+	msgs := make([]kafka.Message, 0, len(offsets))
+	for _, offset := range offsets {
+		msgs = append(msgs, testMessage(partition, offset))
+	}
+	return msgs
+}
+
+// offsetStep - прочитать сообщения track (в порядке чтения) или завершить сообщения complete
+type offsetStep struct {
+	track     []kafka.Message
+	wantReset bool
+	complete  []kafka.Message
+	// wantCommits - закоммиченные на шаге сообщения как "партиция/offset"
+	wantCommits []string
+}
+
+func TestOffsetTracker(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []offsetStep
+	}{
+		{
+			name: "завершение по порядку",
+			steps: []offsetStep{
+				{track: testMessages(0, 0, 1, 2)},
+				{complete: testMessages(0, 0), wantCommits: []string{"0/0"}},
+				{complete: testMessages(0, 1, 2), wantCommits: []string{"0/2"}},
+			},
+		},
+		{
+			name: "завершение не по порядку",
+			steps: []offsetStep{
+				{track: testMessages(0, 0, 1, 2, 3)},
+				{complete: testMessages(0, 2)},
+				{complete: testMessages(0, 3)},
+				{complete: testMessages(0, 0), wantCommits: []string{"0/0"}},
+				{complete: testMessages(0, 1), wantCommits: []string{"0/3"}},
+			},
+		},
+		{
+			name: "пачка из нескольких партиций",
+			steps: []offsetStep{
+				{track: append(testMessages(0, 0, 1), testMessages(1, 5, 6)...)},
+				{complete: append(testMessages(0, 1), testMessages(1, 5)...), wantCommits: []string{"1/5"}},
+				{complete: append(testMessages(1, 6), testMessages(0, 0)...), wantCommits: []string{"1/6", "0/1"}},
+			},
+		},
+		{
+			// после ребалансировки партиция читается с закоммиченного offset: завершения прежнего
+			// чтения забываются, и сообщения надо обработать заново
+			name: "сброс при повторном чтении партиции",
+			steps: []offsetStep{
+				{track: testMessages(0, 0, 1, 2, 3)},
+				{complete: testMessages(0, 0), wantCommits: []string{"0/0"}},
+				{complete: testMessages(0, 2)},
+				{track: testMessages(0, 1), wantReset: true},
+				{track: testMessages(0, 2, 3)},
+				{complete: testMessages(0, 1), wantCommits: []string{"0/1"}},
+				{complete: testMessages(0, 3)},
+				{complete: testMessages(0, 2), wantCommits: []string{"0/3"}},
+			},
+		},
+		{
+			name: "завершение сообщения, прочитанного до сброса",
+			steps: []offsetStep{
+				{track: testMessages(0, 0, 1, 2, 3)},
+				{track: testMessages(0, 2), wantReset: true},
+				{complete: testMessages(0, 0, 1)},
+				{complete: testMessages(0, 2), wantCommits: []string{"0/2"}},
+			},
+		},
+		{
+			name: "повторная доставка того же offset",
+			steps: []offsetStep{
+				{track: testMessages(0, 7)},
+				{track: testMessages(0, 7), wantReset: true},
+				{complete: testMessages(0, 7), wantCommits: []string{"0/7"}},
+				{complete: testMessages(0, 7)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tracker := newOffsetTracker()
+
+			for i, step := range tt.steps {
+				reset := false
+				for _, msg := range step.track {
+					reset = tracker.track(msg) || reset
+				}
+				if reset != step.wantReset {
+					t.Errorf("шаг %d: сброс = %v, want %v", i, reset, step.wantReset)
+				}
+
+				var commits []string
+				for _, commit := range tracker.complete(ctx, step.complete, (&commitRecorder{}).commit) {
+					commits = append(commits, fmt.Sprintf("%d/%d", commit.msg.Partition, commit.msg.Offset))
+				}
+				if !slices.Equal(commits, step.wantCommits) {
+					t.Errorf("шаг %d: коммиты %v, want %v", i, commits, step.wantCommits)
+				}
+			}
+		})
+	}
+}
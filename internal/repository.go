@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OrderRepository хранит заказы и историю их событий; реализации - PostgresRepository и MemoryRepository
+type OrderRepository interface {
+	// Save сохраняет пачку заказов вместе с записями истории атомарно: либо все, либо ничего.
+	// Версия заказа не заменяется событием, которое старше сохраненного
+	Save(ctx context.Context, writes []OrderWrite) error
+	// GetByID возвращает ошибку, оборачивающую ErrOrderNotFound, если заказа нет
+	GetByID(ctx context.Context, orderUID string) (Order, error)
+	List(ctx context.Context, opts ListOptions) ([]Order, error)
+	// Stream передает заказы в fn по одному; ошибка fn прерывает обход и возвращается из Stream
+	Stream(ctx context.Context, opts ListOptions, fn func(Order) error) error
+	// Delete удаляет заказ, история его событий сохраняется
+	Delete(ctx context.Context, orderUID string) error
+	History(ctx context.Context, orderUID string) ([]OrderHistoryEntry, error)
+	// ListBy возвращает все заказы со значением вторичного ключа от новых к старым
+	ListBy(ctx context.Context, index OrderIndex, value string) ([]Order, error)
+	// Search возвращает страницу заказов по фильтрам; некорректный курсор - ошибка, оборачивающая ErrInvalidSearch
+	Search(ctx context.Context, search OrderSearch) (OrderPage, error)
+}
+
+// OrderWrite - версия заказа и запись истории события, из которого она получена.
+// History необязательна
+type OrderWrite struct {
+	Order   Order
+	History *OrderHistoryEntry
+}
+
+// ListOptions выбирает заказы: OrderUIDs - конкретные заказы в порядке order_uid, отсутствующие пропускаются;
+// без OrderUIDs - Limit последних по date_created заказов, Limit <= 0 - все
+type ListOptions struct {
+	OrderUIDs []string
+	Limit     int
+	// BatchSize - сколько заказов Stream читает за один запрос, <= 0 - defaultStreamBatchSize
+	BatchSize int
+}
+
+const defaultStreamBatchSize = 500
+
+// MemoryRepository хранит заказы в памяти процесса, повторяя поведение PostgresRepository
+// (защиту от старых событий и признак applied в истории), для тестов и локальных демонстраций
+type MemoryRepository struct {
+	mu      sync.RWMutex
+	orders  map[string]Order
+	history []OrderHistoryEntry
+	offsets map[historyOffset]bool
+}
+
+type historyOffset struct {
+	topic     string
+	partition int
+	offset    int64
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		orders:  make(map[string]Order),
+		offsets: make(map[historyOffset]bool),
+	}
+}
+
+func (r *MemoryRepository) Save(_ context.Context, writes []OrderWrite) error {
+	// как и в postgres, некорректный заказ отменяет всю пачку до изменения данных
+	for _, write := range writes {
+		_, err := time.Parse(time.RFC3339, write.Order.DateCreated)
+		if err != nil {
+			return fmt.Errorf("некорректный date_created заказа %v: %w. ", write.Order.OrderUID, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, write := range writes {
+		stored, ok := r.orders[write.Order.OrderUID]
+		if !ok || !stored.EventTime.After(write.Order.EventTime) {
+			r.orders[write.Order.OrderUID] = copyOrder(write.Order)
+		}
+
+		if write.History == nil {
+			continue
+		}
+		key := historyOffset{write.History.KafkaTopic, write.History.KafkaPartition, write.History.KafkaOffset}
+		if r.offsets[key] {
+			continue
+		}
+		r.offsets[key] = true
+
+		entry := *write.History
+		entry.Applied = r.orders[entry.OrderUID].EventTime.Equal(entry.EventTime)
+		entry.RecordedAt = now
+		r.history = append(r.history, entry)
+	}
+
+	return nil
+}
+
+func (r *MemoryRepository) GetByID(_ context.Context, orderUID string) (Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	order, ok := r.orders[orderUID]
+	if !ok {
+		return Order{}, fmt.Errorf("заказ с order_uid=%s: %w", orderUID, ErrOrderNotFound)
+	}
+	return copyOrder(order), nil
+}
+
+func (r *MemoryRepository) List(_ context.Context, opts ListOptions) ([]Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.list(opts), nil
+}
+
+// Stream обходит снимок заказов, поэтому fn может обращаться к репозиторию
+func (r *MemoryRepository) Stream(ctx context.Context, opts ListOptions, fn func(Order) error) error {
+	r.mu.RLock()
+	orders := r.list(opts)
+	r.mu.RUnlock()
+
+	for _, order := range orders {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Delete(_ context.Context, orderUID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.orders[orderUID]; !ok {
+		return fmt.Errorf("заказ с order_uid=%s: %w", orderUID, ErrOrderNotFound)
+	}
+	delete(r.orders, orderUID)
+	return nil
+}
+
+func (r *MemoryRepository) History(_ context.Context, orderUID string) ([]OrderHistoryEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := []OrderHistoryEntry{}
+	for _, entry := range r.history {
+		if entry.OrderUID == orderUID {
+			history = append(history, copyHistoryEntry(entry))
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].EventTime.Before(history[j].EventTime)
+	})
+	return history, nil
+}
+
+func (r *MemoryRepository) ListBy(_ context.Context, index OrderIndex, value string) ([]Order, error) {
+	if _, err := index.column(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orders := []Order{}
+	for _, order := range r.orders {
+		if value != "" && index.value(order) == value {
+			orders = append(orders, copyOrder(order))
+		}
+	}
+	sortByDateCreatedDesc(orders)
+	return orders, nil
+}
+
+func (r *MemoryRepository) Search(_ context.Context, search OrderSearch) (OrderPage, error) {
+	r.mu.RLock()
+	var orders []Order
+	for _, order := range r.orders {
+		if search.matches(order) {
+			orders = append(orders, copyOrder(order))
+		}
+	}
+	r.mu.RUnlock()
+
+	return search.page(orders)
+}
+
+func (r *MemoryRepository) list(opts ListOptions) []Order {
+	var orders []Order
+	if len(opts.OrderUIDs) > 0 {
+		for _, orderUID := range opts.OrderUIDs {
+			if order, ok := r.orders[orderUID]; ok {
+				orders = append(orders, copyOrder(order))
+			}
+		}
+		sort.Slice(orders, func(i, j int) bool {
+			return orders[i].OrderUID < orders[j].OrderUID
+		})
+		return orders
+	}
+
+	for _, order := range r.orders {
+		orders = append(orders, copyOrder(order))
+	}
+	sortByDateCreatedDesc(orders)
+	if opts.Limit > 0 && len(orders) > opts.Limit {
+		orders = orders[:opts.Limit]
+	}
+	return orders
+}
+
+// sortByDateCreatedDesc упорядочивает заказы как PostgresRepository: date_created, затем order_uid по убыванию
+func sortByDateCreatedDesc(orders []Order) {
+	sort.Slice(orders, func(i, j int) bool {
+		a, _ := time.Parse(time.RFC3339, orders[i].DateCreated)
+		b, _ := time.Parse(time.RFC3339, orders[j].DateCreated)
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return orders[i].OrderUID > orders[j].OrderUID
+	})
+}
+
+// copyOrder не дает вызывающему коду изменить товары сохраненного заказа через общий срез
+func copyOrder(order Order) Order {
+	if order.Items != nil {
+		order.Items = append([]Item(nil), order.Items...)
+	}
+	return order
+}
+
+// copyHistoryEntry оставляет пустые списки пустыми, а не nil: из postgres они читаются как [], а не null
+func copyHistoryEntry(entry OrderHistoryEntry) OrderHistoryEntry {
+	entry.ChangedFields = append([]string{}, entry.ChangedFields...)
+	entry.ItemStatusTransitions = append([]ItemStatusTransition{}, entry.ItemStatusTransitions...)
+	return entry
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+}
+
+// значения по умолчанию покрывают переключение мастера postgres, которое длится до ~30 секунд
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  8,
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     30 * time.Second,
+		Multiplier:   2,
+	}
+}
+
+func (p RetryPolicy) ShouldRetry(err error, attempt int) bool {
+	return attempt < p.MaxAttempts && isTransientSaveError(err)
+}
+
+// isTransientSaveError - временная ошибка бд на этапе сохранения, которую имеет смысл повторить
+func isTransientSaveError(err error) bool {
+	var procErr *ProcessingError
+	if !errors.As(err, &procErr) || procErr.Stage != StageSave {
+		return false
+	}
+
+	return IsTransientError(err)
+}
+
+// экспоненциальная задержка с jitter: половина интервала фиксирована, вторая половина случайна
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if delay >= float64(p.MaxDelay) {
+			delay = float64(p.MaxDelay)
+			break
+		}
+	}
+
+	half := time.Duration(delay / 2)
+	if half <= 0 {
+		return 0
+	}
+	return half + rand.N(half)
+}
+
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return isTransientPgCode(pgErr.Code)
+	}
+
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr)
+}
+
+func isTransientPgCode(code string) bool {
+	switch {
+	case strings.HasPrefix(code, "08"): // connection exception
+		return true
+	case code == "40001", // serialization_failure
+		code == "40P01", // deadlock_detected
+		code == "55P03", // lock_not_available
+		code == "53300", // too_many_connections
+		code == "57P01", // admin_shutdown
+		code == "57P02", // crash_shutdown
+		code == "57P03": // cannot_connect_now
+		return true
+	}
+	// ограничения (23xxx), ошибки данных (22xxx) и прочее повторять бессмысленно
+	return false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
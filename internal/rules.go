@@ -0,0 +1,348 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RulesSourceBuiltin  = "builtin"
+	RulesSourceFile     = "file"
+	RulesSourcePostgres = "postgres"
+)
+
+// e164MaxDigits - максимальное число цифр номера вместе с кодом страны по E.164
+const e164MaxDigits = 15
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ReferenceRules - справочники и форматы, которые отличаются между рынками
+type ReferenceRules struct {
+	// коды валют ISO 4217
+	Currencies  []string         `yaml:"currencies"`
+	Banks       []string         `yaml:"banks"`
+	Providers   []string         `yaml:"providers"`
+	Phones      []PhoneRule      `yaml:"phones"`
+	PostalCodes []PostalCodeRule `yaml:"postal_codes"`
+}
+
+// PhoneRule описывает номера E.164 страны: номер выбирается по самому длинному совпавшему CallingCode,
+// MinDigits и MaxDigits считаются вместе с кодом страны. Пустой CallingCode подходит любому номеру
+type PhoneRule struct {
+	Country     string `yaml:"country"`
+	CallingCode string `yaml:"calling_code"`
+	MinDigits   int    `yaml:"min_digits"`
+	MaxDigits   int    `yaml:"max_digits"`
+}
+
+// PostalCodeRule задает формат индекса страны; правило с пустым Country применяется к странам без своих правил
+type PostalCodeRule struct {
+	Country string `yaml:"country"`
+	Pattern string `yaml:"pattern"`
+}
+
+// DefaultReferenceRules - правила, с которыми сервис работал до появления справочников
+func DefaultReferenceRules() ReferenceRules {
+	return ReferenceRules{
+		Currencies: []string{"USD", "RUR"},
+		Banks:      []string{"alpha", "tbank", "sber"},
+		Providers:  []string{"wbpay", "other"},
+		Phones: []PhoneRule{
+			{MinDigits: 11, MaxDigits: 11},
+		},
+		PostalCodes: []PostalCodeRule{
+			{Pattern: `^\d{5,7}$`},
+		},
+	}
+}
+
+type referenceRules struct {
+	source     ReferenceRules
+	currencies map[string]bool
+	banks      map[string]bool
+	providers  map[string]bool
+	phones     []PhoneRule
+	postal     map[string][]*regexp.Regexp
+}
+
+var builtinRules = mustCompileRules(DefaultReferenceRules())
+
+func mustCompileRules(rules ReferenceRules) *referenceRules {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// compileRules проверяет справочники целиком и возвращает все ошибки сразу
+func compileRules(rules ReferenceRules) (*referenceRules, error) {
+	var errs []error
+	compiled := &referenceRules{
+		source:     rules,
+		currencies: make(map[string]bool),
+		banks:      make(map[string]bool),
+		providers:  make(map[string]bool),
+		postal:     make(map[string][]*regexp.Regexp),
+	}
+
+	// пустой справочник отклонил бы все заказы; при перезагрузке ошибка оставляет прежние справочники
+	for _, list := range []struct {
+		name string
+		size int
+	}{
+		{"currencies", len(rules.Currencies)},
+		{"banks", len(rules.Banks)},
+		{"providers", len(rules.Providers)},
+		{"phones", len(rules.Phones)},
+	} {
+		if list.size == 0 {
+			errs = append(errs, fmt.Errorf("справочник %v пуст", list.name))
+		}
+	}
+
+	for _, currency := range rules.Currencies {
+		if !currencyCodePattern.MatchString(currency) {
+			errs = append(errs, fmt.Errorf("код валюты %q не соответствует ISO 4217", currency))
+		}
+		compiled.currencies[currency] = true
+	}
+	for _, bank := range rules.Banks {
+		compiled.banks[bank] = true
+	}
+	for _, provider := range rules.Providers {
+		compiled.providers[provider] = true
+	}
+
+	for _, rule := range rules.Phones {
+		if strings.Trim(rule.CallingCode, "0123456789") != "" {
+			errs = append(errs, fmt.Errorf("код страны %q %v должен состоять из цифр", rule.CallingCode, withCountry("телефона", rule.Country)))
+		}
+		if rule.MinDigits < len(rule.CallingCode)+1 || rule.MinDigits > rule.MaxDigits || rule.MaxDigits > e164MaxDigits {
+			errs = append(errs, fmt.Errorf("%v должна быть в пределах E.164: min_digits=%v, max_digits=%v",
+				withCountry("длина номера телефона", rule.Country), rule.MinDigits, rule.MaxDigits))
+		}
+		compiled.phones = append(compiled.phones, rule)
+	}
+	sort.SliceStable(compiled.phones, func(i, j int) bool {
+		return len(compiled.phones[i].CallingCode) > len(compiled.phones[j].CallingCode)
+	})
+
+	for _, rule := range rules.PostalCodes {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", withCountry("некорректный формат индекса", rule.Country), err))
+			continue
+		}
+		compiled.postal[rule.Country] = append(compiled.postal[rule.Country], pattern)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("некорректные справочники валидации:\n%w", errors.Join(errs...))
+	}
+	return compiled, nil
+}
+
+// withCountry дописывает к тексту страну правила; правило без страны применяется ко всем номерам
+func withCountry(text, country string) string {
+	if country == "" {
+		return text
+	}
+	return text + " " + country
+}
+
+func allowedValues(values []string) string {
+	return "'" + strings.Join(values, "', '") + "'"
+}
+
+// checkPhone возвращает страну, правило которой подошло номеру, и код нарушения, если номер не подходит
+func (rules *referenceRules) checkPhone(phone string) (country, code string, err error) {
+	if !strings.HasPrefix(phone, "+") {
+		return "", ViolationInvalidFormat, fmt.Errorf("значение должно начинаться с '+'")
+	}
+
+	digits := phone[1:]
+	for _, item := range digits {
+		if item < '0' || item > '9' {
+			return "", ViolationInvalidFormat, fmt.Errorf("содержит недопустимый символ %c", item)
+		}
+	}
+	if len(digits) > e164MaxDigits {
+		return "", ViolationInvalidFormat, fmt.Errorf("номер длиннее %v цифр", e164MaxDigits)
+	}
+
+	for _, rule := range rules.phones {
+		if !strings.HasPrefix(digits, rule.CallingCode) {
+			continue
+		}
+		if len(digits) < rule.MinDigits || len(digits) > rule.MaxDigits {
+			return rule.Country, ViolationInvalidFormat, fmt.Errorf("%v должна быть от %v до %v цифр",
+				withCountry("длина номера", rule.Country), rule.MinDigits, rule.MaxDigits)
+		}
+		return rule.Country, "", nil
+	}
+
+	return "", ViolationNotAllowed, fmt.Errorf("нет правила для кода страны номера")
+}
+
+// checkPostalCode проверяет индекс по правилам страны, определенной по телефону получателя
+func (rules *referenceRules) checkPostalCode(zip, country string) error {
+	patterns := rules.postal[country]
+	if len(patterns) == 0 {
+		patterns = rules.postal[""]
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	for _, pattern := range patterns {
+		if pattern.MatchString(zip) {
+			return nil
+		}
+	}
+	if country == "" {
+		return fmt.Errorf("индекс не соответствует допустимому формату")
+	}
+	return fmt.Errorf("индекс не соответствует формату страны %v", country)
+}
+
+// RulesLoader загружает актуальные справочники из внешнего источника
+type RulesLoader interface {
+	LoadRules(ctx context.Context) (ReferenceRules, error)
+}
+
+// NewRulesLoader возвращает nil для встроенных правил, которые не перезагружаются
+func NewRulesLoader(cfg RulesConfig, db *sql.DB) RulesLoader {
+	switch cfg.Source {
+	case RulesSourceFile:
+		return fileRulesLoader{path: cfg.File}
+	case RulesSourcePostgres:
+		return pgRulesLoader{db: db}
+	default:
+		return nil
+	}
+}
+
+type fileRulesLoader struct {
+	path string
+}
+
+func (l fileRulesLoader) LoadRules(_ context.Context) (ReferenceRules, error) {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return ReferenceRules{}, fmt.Errorf("ошибка чтения файла справочников: %w", err)
+	}
+
+	var rules ReferenceRules
+	err = yaml.Unmarshal(data, &rules)
+	if err != nil {
+		return ReferenceRules{}, fmt.Errorf("ошибка разбора файла справочников %v: %w", l.path, err)
+	}
+	return rules, nil
+}
+
+type pgRulesLoader struct {
+	db *sql.DB
+}
+
+func (l pgRulesLoader) LoadRules(ctx context.Context) (ReferenceRules, error) {
+	query := `SELECT kind, country, value, min_digits, max_digits
+		FROM reference_rules
+		ORDER BY kind, country, value`
+	rows, err := l.db.QueryContext(ctx, query)
+	if err != nil {
+		return ReferenceRules{}, fmt.Errorf("ошибка чтения справочников из бд: %w", err)
+	}
+	defer rows.Close()
+
+	var rules ReferenceRules
+	for rows.Next() {
+		var kind, country, value string
+		var minDigits, maxDigits sql.NullInt64
+		err := rows.Scan(&kind, &country, &value, &minDigits, &maxDigits)
+		if err != nil {
+			return ReferenceRules{}, fmt.Errorf("ошибка чтения справочников из бд: %w", err)
+		}
+
+		switch kind {
+		case "currency":
+			rules.Currencies = append(rules.Currencies, value)
+		case "bank":
+			rules.Banks = append(rules.Banks, value)
+		case "provider":
+			rules.Providers = append(rules.Providers, value)
+		case "phone":
+			rules.Phones = append(rules.Phones, PhoneRule{
+				Country:     country,
+				CallingCode: value,
+				MinDigits:   int(minDigits.Int64),
+				MaxDigits:   int(maxDigits.Int64),
+			})
+		case "postal_code":
+			rules.PostalCodes = append(rules.PostalCodes, PostalCodeRule{Country: country, Pattern: value})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ReferenceRules{}, fmt.Errorf("ошибка чтения справочников из бд: %w", err)
+	}
+
+	return rules, nil
+}
+
+// ReloadRules загружает справочники и применяет их, только если они изменились и корректны;
+// при ошибке валидатор продолжает работать с прежними правилами
+func ReloadRules(ctx context.Context, v *Validator, loader RulesLoader) error {
+	rules, err := loader.LoadRules(ctx)
+	if err != nil {
+		rulesReloads.WithLabelValues("error").Inc()
+		return err
+	}
+	if reflect.DeepEqual(rules, v.currentRules().source) {
+		return nil
+	}
+
+	err = v.SetRules(rules)
+	if err != nil {
+		rulesReloads.WithLabelValues("error").Inc()
+		return err
+	}
+	rulesReloads.WithLabelValues("ok").Inc()
+	Logger(ctx).Info("Справочники валидации обновлены",
+		slog.Int("currencies", len(rules.Currencies)),
+		slog.Int("banks", len(rules.Banks)),
+		slog.Int("providers", len(rules.Providers)),
+		slog.Int("phone_rules", len(rules.Phones)),
+		slog.Int("postal_code_rules", len(rules.PostalCodes)),
+	)
+	return nil
+}
+
+// WatchRules перезагружает справочники каждые interval до отмены ctx
+func WatchRules(ctx context.Context, v *Validator, loader RulesLoader, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := ReloadRules(ctx, v, loader)
+			if err != nil {
+				Logger(ctx).Error("Ошибка обновления справочников валидации, используются прежние",
+					slog.Any(LogKeyError, err))
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPhone(t *testing.T) {
+	rules := mustCompileRules(ReferenceRules{
+		Currencies: []string{"RUB"},
+		Banks:      []string{"alpha"},
+		Providers:  []string{"wbpay"},
+		Phones: []PhoneRule{
+			{Country: "RU", CallingCode: "7", MinDigits: 11, MaxDigits: 11},
+			{MinDigits: 10, MaxDigits: 12},
+		},
+	})
+
+	tests := []struct {
+		phone       string
+		wantCountry string
+		wantCode    string
+		wantErr     string
+	}{
+		{phone: "+79001234567", wantCountry: "RU"},
+		{phone: "+9720000000"},
+		{phone: "+7900123456", wantCountry: "RU", wantCode: ViolationInvalidFormat, wantErr: "длина номера RU должна быть от 11 до 11 цифр"},
+		// правило без страны: в сообщении нет пустого места на ее месте
+		{phone: "+972000", wantCode: ViolationInvalidFormat, wantErr: "длина номера должна быть от 10 до 12 цифр"},
+		{phone: "79001234567", wantCode: ViolationInvalidFormat, wantErr: "значение должно начинаться с '+'"},
+		{phone: "+7900123456a", wantCode: ViolationInvalidFormat, wantErr: "содержит недопустимый символ a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.phone, func(t *testing.T) {
+			country, code, err := rules.checkPhone(tt.phone)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if country != tt.wantCountry || code != tt.wantCode || gotErr != tt.wantErr {
+				t.Errorf("checkPhone(%q) = %q, %q, %q, want %q, %q, %q",
+					tt.phone, country, code, gotErr, tt.wantCountry, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompileRulesMessages(t *testing.T) {
+	_, err := compileRules(ReferenceRules{
+		Currencies: []string{"RUB"},
+		Banks:      []string{"alpha"},
+		Providers:  []string{"wbpay"},
+		Phones: []PhoneRule{
+			{Country: "RU", CallingCode: "7x", MinDigits: 11, MaxDigits: 11},
+			{MinDigits: 11, MaxDigits: 16},
+		},
+		PostalCodes: []PostalCodeRule{{Pattern: "("}},
+	})
+	if err == nil {
+		t.Fatal("compileRules() error = nil")
+	}
+
+	for _, want := range []string{
+		`код страны "7x" телефона RU должен состоять из цифр`,
+		"длина номера телефона должна быть в пределах E.164: min_digits=11, max_digits=16",
+		"некорректный формат индекса: ",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("compileRules() error = %v, want %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "  ") {
+		t.Errorf("compileRules() error содержит двойной пробел: %q", err)
+	}
+}
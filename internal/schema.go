@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const StageSchema = "schema"
+
+const (
+	ViolationInvalidType  = "invalid_type"
+	ViolationUnknownField = "unknown_field"
+)
+
+const orderSchemaID = "https://github.com/bdrlv/l0/order.schema.json"
+
+// JSONSchema - подмножество JSON Schema 2020-12, которого достаточно для описания сообщения заказа
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	// MultipleOf хранится десятичной строкой, чтобы шаг 0.01 проверялся точно
+	MultipleOf json.Number `json:"multipleOf,omitempty"`
+}
+
+// jsonSchemaProvider реализуют типы, у которых в JSON другое представление, чем в Go (Money)
+type jsonSchemaProvider interface {
+	JSONSchema() *JSONSchema
+}
+
+var jsonSchemaProviderType = reflect.TypeOf((*jsonSchemaProvider)(nil)).Elem()
+
+var orderSchema = sync.OnceValue(func() *JSONSchema {
+	schema := schemaForType(reflect.TypeOf(Order{}))
+	schema.Schema = "https://json-schema.org/draft/2020-12/schema"
+	schema.ID = orderSchemaID
+	schema.Title = "Order"
+	return schema
+})
+
+// OrderSchema возвращает JSON Schema сообщения заказа, построенную по типу Order,
+// поэтому она не расходится с тем, что сервис умеет разобрать
+func OrderSchema() *JSONSchema {
+	return orderSchema()
+}
+
+// schemaForType считает обязательными все поля структуры, кроме помеченных omitempty,
+// и запрещает поля, которых нет в типе
+func schemaForType(t reflect.Type) *JSONSchema {
+	if t.Implements(jsonSchemaProviderType) {
+		return reflect.Zero(t).Interface().(jsonSchemaProvider).JSONSchema()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: schemaForType(t.Elem())}
+	case reflect.Pointer:
+		return schemaForType(t.Elem())
+	case reflect.Struct:
+		additional := false
+		schema := &JSONSchema{
+			Type:                 "object",
+			Properties:           make(map[string]*JSONSchema),
+			AdditionalProperties: &additional,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			schema.Properties[name] = schemaForType(field.Type)
+			if !strings.Contains(opts, "omitempty") {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		sort.Strings(schema.Required)
+		return schema
+	default:
+		panic(fmt.Sprintf("тип %v не поддерживается схемой заказа", t))
+	}
+}
+
+// ValidateOrderSchema проверяет сырое сообщение по схеме заказа до десериализации:
+// json.Unmarshal молча пропускает неизвестные поля и отсутствующие обязательные
+func ValidateOrderSchema(data []byte) ValidationResult {
+	var result ValidationResult
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	if err == nil && decoder.More() {
+		err = fmt.Errorf("после заказа есть лишние данные")
+	}
+	if err != nil {
+		result.add("", ViolationInvalidJSON, "ошибка десеарилизации заказа: %v", err)
+		return result
+	}
+
+	validateSchemaValue(OrderSchema(), value, "", &result)
+	return result
+}
+
+func validateSchemaValue(schema *JSONSchema, value any, path string, r *ValidationResult) {
+	if !schemaTypeMatches(schema.Type, value) {
+		r.add(schemaPath(path), ViolationInvalidType, "ожидается %v, получено %v", schema.Type, jsonTypeName(value))
+		return
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		if schema.MultipleOf != "" && !isMultipleOf(v, schema.MultipleOf) {
+			r.add(schemaPath(path), ViolationInvalidFormat, "значение %v должно быть кратно %v", v, schema.MultipleOf)
+		}
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				r.add(joinSchemaPath(path, name), ViolationRequired, "обязательное поле отсутствует")
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					r.add(joinSchemaPath(path, name), ViolationUnknownField, "поле не описано в схеме заказа")
+				}
+				continue
+			}
+			validateSchemaValue(property, v[name], joinSchemaPath(path, name), r)
+		}
+	case []any:
+		for i, item := range v {
+			validateSchemaValue(schema.Items, item, fmt.Sprintf("%v[%d]", path, i), r)
+		}
+	}
+}
+
+func schemaTypeMatches(schemaType string, value any) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		return schemaType == "object"
+	case []any:
+		return schemaType == "array"
+	case string:
+		return schemaType == "string"
+	case bool:
+		return schemaType == "boolean"
+	case json.Number:
+		if schemaType == "number" {
+			return true
+		}
+		// 18.17 или 1e3 в целочисленном поле - ошибка продюсера, а не повод округлять
+		_, err := v.Int64()
+		return schemaType == "integer" && err == nil
+	default:
+		return false
+	}
+}
+
+func isMultipleOf(value, step json.Number) bool {
+	v, err := parseDecimal(value.String())
+	if err != nil {
+		return false
+	}
+	s, err := parseDecimal(step.String())
+	if err != nil || s.Sign() == 0 {
+		return false
+	}
+	return v.Quo(v, s).IsInt()
+}
+
+func jsonTypeName(value any) string {
+	switch v := value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number " + v.String()
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "$"
+	}
+	return path
+}
@@ -0,0 +1,508 @@
+package internal
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SearchSortDateCreated = "date_created"
+	SearchSortAmount      = "amount"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+var ErrInvalidSearch = errors.New("некорректные параметры поиска")
+
+// OrderSearch - фильтры, сортировка и страница поиска заказов. Пустые фильтры не применяются;
+// фильтры товара (Brand, NmID, ItemStatus) должны выполняться для одного и того же товара
+type OrderSearch struct {
+	CustomerID      string
+	TrackNumber     string
+	DeliveryService string
+	Phone           string
+	Bank            string
+	Currency        string
+	Brand           string
+	NmID            int
+	ItemStatus      *int
+	// DateFrom включительно, DateTo не включительно
+	DateFrom time.Time
+	DateTo   time.Time
+
+	// Sort - SearchSortDateCreated (по умолчанию) или SearchSortAmount; при равенстве порядок задает order_uid
+	Sort string
+	Desc bool
+	// Limit <= 0 - defaultSearchLimit
+	Limit int
+	// Cursor - NextCursor предыдущей страницы, пустой - первая страница
+	Cursor string
+	// Fields - поля ответа (payment.amount, items.status), пустой - заказ целиком
+	Fields []string
+}
+
+// OrderPage - страница найденных заказов; NextCursor пустой на последней странице
+type OrderPage struct {
+	Orders     []Order
+	NextCursor string
+}
+
+type SearchResult struct {
+	Orders     []any  `json:"orders"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ParseOrderSearch разбирает параметры GET /orders; неизвестный параметр - ошибка, чтобы опечатка
+// в фильтре не превращалась в выдачу всех заказов
+func ParseOrderSearch(query url.Values) (OrderSearch, error) {
+	search := OrderSearch{Sort: SearchSortDateCreated, Desc: true}
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	parseInt := func(name, value string) int {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			fail("%v должен быть целым числом", name)
+		}
+		return n
+	}
+	parseTime := func(name, value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fail("%v должен быть в формате RFC3339", name)
+		}
+		return t
+	}
+
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	// ошибки в одном и том же порядке при одинаковом запросе
+	sort.Strings(names)
+	for _, name := range names {
+		value := query.Get(name)
+		switch name {
+		case "customer_id":
+			search.CustomerID = value
+		case "track_number":
+			search.TrackNumber = value
+		case "delivery_service":
+			search.DeliveryService = value
+		case "delivery.phone":
+			search.Phone = value
+		case "payment.bank":
+			search.Bank = value
+		case "payment.currency":
+			search.Currency = value
+		case "items.brand":
+			search.Brand = value
+		case "items.nm_id":
+			search.NmID = parseInt(name, value)
+		case "items.status":
+			status := parseInt(name, value)
+			search.ItemStatus = &status
+		case "date_created_from":
+			search.DateFrom = parseTime(name, value)
+		case "date_created_to":
+			search.DateTo = parseTime(name, value)
+		case "sort":
+			search.Sort, search.Desc = strings.TrimPrefix(value, "-"), strings.HasPrefix(value, "-")
+			if search.Sort != SearchSortDateCreated && search.Sort != SearchSortAmount {
+				fail("sort должен быть %v или %v, для убывания с префиксом '-'", SearchSortDateCreated, SearchSortAmount)
+			}
+		case "limit":
+			search.Limit = parseInt(name, value)
+			if search.Limit < 1 || search.Limit > maxSearchLimit {
+				fail("limit должен быть от 1 до %v", maxSearchLimit)
+			}
+		case "cursor":
+			search.Cursor = value
+		case "fields":
+			for _, field := range strings.Split(value, ",") {
+				field = strings.TrimSpace(field)
+				if !orderFieldExists(field) {
+					fail("поле %q не описано в схеме заказа", field)
+					continue
+				}
+				search.Fields = append(search.Fields, field)
+			}
+		default:
+			fail("неизвестный параметр %q", name)
+		}
+	}
+
+	if _, err := search.after(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return OrderSearch{}, fmt.Errorf("%w: %w", ErrInvalidSearch, errors.Join(errs...))
+	}
+	return search, nil
+}
+
+// SearchOrders ищет заказы в бд, минуя кеш, и оставляет в ответе только запрошенные поля
+func SearchOrders(ctx context.Context, repo OrderRepository, search OrderSearch) (SearchResult, error) {
+	page, err := repo.Search(ctx, search)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("ошибка поиска заказов: %w", err)
+	}
+
+	result := SearchResult{Orders: make([]any, 0, len(page.Orders)), NextCursor: page.NextCursor}
+	for _, order := range page.Orders {
+		projected, err := projectOrder(order, search.Fields)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		result.Orders = append(result.Orders, projected)
+	}
+	return result, nil
+}
+
+func (s OrderSearch) limit() int {
+	if s.Limit <= 0 {
+		return defaultSearchLimit
+	}
+	return min(s.Limit, maxSearchLimit)
+}
+
+func (s OrderSearch) sortName() string {
+	if s.Desc {
+		return "-" + s.Sort
+	}
+	return s.Sort
+}
+
+// searchKey - значения, по которым упорядочена выдача; курсор хранит ключ последнего заказа страницы
+type searchKey struct {
+	DateCreated time.Time `json:"date_created"`
+	Amount      int64     `json:"amount"`
+	OrderUID    string    `json:"order_uid"`
+}
+
+type searchCursor struct {
+	Sort string    `json:"sort"`
+	Key  searchKey `json:"key"`
+}
+
+func orderSearchKey(order Order) searchKey {
+	dateCreated, _ := time.Parse(time.RFC3339, order.DateCreated)
+	return searchKey{DateCreated: dateCreated, Amount: order.Payment.Amount.Minor, OrderUID: order.OrderUID}
+}
+
+// compare упорядочивает ключи в порядке выдачи
+func (s OrderSearch) compare(a, b searchKey) int {
+	var c int
+	if s.Sort == SearchSortAmount {
+		c = cmp.Compare(a.Amount, b.Amount)
+	} else {
+		c = a.DateCreated.Compare(b.DateCreated)
+	}
+	if c == 0 {
+		c = strings.Compare(a.OrderUID, b.OrderUID)
+	}
+	if s.Desc {
+		c = -c
+	}
+	return c
+}
+
+func (s OrderSearch) cursorAfter(key searchKey) string {
+	data, _ := json.Marshal(searchCursor{Sort: s.sortName(), Key: key})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// after возвращает ключ, после которого начинается страница; курсор другой сортировки - ошибка
+func (s OrderSearch) after() (*searchKey, error) {
+	if s.Cursor == "" {
+		return nil, nil
+	}
+
+	var cursor searchCursor
+	data, err := base64.RawURLEncoding.DecodeString(s.Cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("некорректный cursor")
+	}
+	if cursor.Sort != s.sortName() {
+		return nil, fmt.Errorf("cursor получен для сортировки %v, а не %v", cursor.Sort, s.sortName())
+	}
+	return &cursor.Key, nil
+}
+
+// matches повторяет условия searchOrdersInDB для MemoryRepository
+func (s OrderSearch) matches(order Order) bool {
+	if s.CustomerID != "" && order.CustomerID != s.CustomerID ||
+		s.TrackNumber != "" && order.TrackNumber != s.TrackNumber ||
+		s.DeliveryService != "" && order.DeliveryService != s.DeliveryService ||
+		s.Phone != "" && order.Delivery.Phone != s.Phone ||
+		s.Bank != "" && order.Payment.Bank != s.Bank ||
+		s.Currency != "" && order.Payment.Currency != s.Currency {
+		return false
+	}
+
+	dateCreated, _ := time.Parse(time.RFC3339, order.DateCreated)
+	if !s.DateFrom.IsZero() && dateCreated.Before(s.DateFrom) ||
+		!s.DateTo.IsZero() && !dateCreated.Before(s.DateTo) {
+		return false
+	}
+
+	if s.Brand == "" && s.NmID == 0 && s.ItemStatus == nil {
+		return true
+	}
+	for _, item := range order.Items {
+		if (s.Brand == "" || item.Brand == s.Brand) &&
+			(s.NmID == 0 || item.NmID == s.NmID) &&
+			(s.ItemStatus == nil || item.Status == *s.ItemStatus) {
+			return true
+		}
+	}
+	return false
+}
+
+// page сортирует подходящие заказы и вырезает страницу после курсора
+func (s OrderSearch) page(orders []Order) (OrderPage, error) {
+	after, err := s.after()
+	if err != nil {
+		return OrderPage{}, fmt.Errorf("%w: %w", ErrInvalidSearch, err)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return s.compare(orderSearchKey(orders[i]), orderSearchKey(orders[j])) < 0
+	})
+	if after != nil {
+		start := sort.Search(len(orders), func(i int) bool {
+			return s.compare(orderSearchKey(orders[i]), *after) > 0
+		})
+		orders = orders[start:]
+	}
+
+	page := OrderPage{Orders: orders}
+	if len(orders) > s.limit() {
+		page.Orders = orders[:s.limit()]
+		page.NextCursor = s.cursorAfter(orderSearchKey(page.Orders[len(page.Orders)-1]))
+	}
+	return page, nil
+}
+
+// searchOrdersInDB сначала выбирает страницу order_uid по индексируемым условиям и ключу сортировки,
+// затем читает сами заказы одним запросом
+func searchOrdersInDB(ctx context.Context, db *sql.DB, s OrderSearch) (OrderPage, error) {
+	after, err := s.after()
+	if err != nil {
+		return OrderPage{}, fmt.Errorf("%w: %w", ErrInvalidSearch, err)
+	}
+
+	var where []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	equal := func(column, value string) {
+		if value != "" {
+			where = append(where, column+" = "+arg(value))
+		}
+	}
+	equal("o.customer_id", s.CustomerID)
+	equal("o.track_number", s.TrackNumber)
+	equal("o.delivery_service", s.DeliveryService)
+	equal("d.phone", s.Phone)
+	equal("p.bank", s.Bank)
+	equal("p.currency", s.Currency)
+	if !s.DateFrom.IsZero() {
+		where = append(where, "o.date_created >= "+arg(s.DateFrom))
+	}
+	if !s.DateTo.IsZero() {
+		where = append(where, "o.date_created < "+arg(s.DateTo))
+	}
+
+	var itemWhere []string
+	if s.Brand != "" {
+		itemWhere = append(itemWhere, "i.brand = "+arg(s.Brand))
+	}
+	if s.NmID != 0 {
+		itemWhere = append(itemWhere, "i.nm_id = "+arg(s.NmID))
+	}
+	if s.ItemStatus != nil {
+		itemWhere = append(itemWhere, "oi.status = "+arg(*s.ItemStatus))
+	}
+	if len(itemWhere) > 0 {
+		where = append(where, `EXISTS (
+			SELECT 1 FROM order_items oi
+			JOIN items i ON i.chrt_id = oi.chrt_id
+			WHERE oi.order_uid = o.order_uid AND `+strings.Join(itemWhere, " AND ")+`
+		)`)
+	}
+
+	sortColumn, direction, beyond := "o.date_created", "ASC", ">"
+	if s.Sort == SearchSortAmount {
+		sortColumn = "COALESCE(p.amount, 0)"
+	}
+	if s.Desc {
+		direction, beyond = "DESC", "<"
+	}
+	if after != nil {
+		var value any = after.DateCreated
+		if s.Sort == SearchSortAmount {
+			value = NewMoney(after.Amount, "")
+		}
+		where = append(where, fmt.Sprintf("(%v, o.order_uid) %v (%v, %v)", sortColumn, beyond, arg(value), arg(after.OrderUID)))
+	}
+
+	query := `
+		SELECT o.order_uid, o.date_created, COALESCE(p.amount, 0)
+		FROM orders o
+		LEFT JOIN delivery d ON o.order_uid = d.order_uid
+		LEFT JOIN payment p ON o.order_uid = p.order_uid`
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, "\n\t\t\tAND ")
+	}
+	// лишний заказ показывает, что есть следующая страница
+	query += fmt.Sprintf("\n\t\tORDER BY %v %v, o.order_uid %v\n\t\tLIMIT %v", sortColumn, direction, direction, arg(s.limit()+1))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return OrderPage{}, fmt.Errorf("ошибка выполнения запроса: %w. ", err)
+	}
+	defer rows.Close()
+
+	var keys []searchKey
+	for rows.Next() {
+		var key searchKey
+		var amount Money
+		err := rows.Scan(&key.OrderUID, &key.DateCreated, &amount)
+		if err != nil {
+			return OrderPage{}, fmt.Errorf("ошибка сканирования строки: %w. ", err)
+		}
+		key.Amount = amount.Minor
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return OrderPage{}, fmt.Errorf("ошибка при итерации по строкам: %w", err)
+	}
+
+	var page OrderPage
+	if len(keys) > s.limit() {
+		keys = keys[:s.limit()]
+		page.NextCursor = s.cursorAfter(keys[len(keys)-1])
+	}
+	if len(keys) == 0 {
+		return page, nil
+	}
+
+	uids := make([]string, len(keys))
+	for i, key := range keys {
+		uids[i] = key.OrderUID
+	}
+	orders, err := getOrdersByIDsFromDB(ctx, db, uids)
+	if err != nil {
+		return OrderPage{}, err
+	}
+	byUID := make(map[string]Order, len(orders))
+	for _, order := range orders {
+		byUID[order.OrderUID] = order
+	}
+	for _, key := range keys {
+		// заказ мог быть удален между запросами
+		if order, ok := byUID[key.OrderUID]; ok {
+			page.Orders = append(page.Orders, order)
+		}
+	}
+	return page, nil
+}
+
+// orderFieldExists проверяет путь поля по схеме заказа; у массивов путь продолжается полями элемента
+func orderFieldExists(path string) bool {
+	schema := OrderSchema()
+	for _, name := range strings.Split(path, ".") {
+		if schema.Type == "array" {
+			schema = schema.Items
+		}
+		property, ok := schema.Properties[name]
+		if !ok {
+			return false
+		}
+		schema = property
+	}
+	return true
+}
+
+// projectOrder оставляет в заказе только fields; items.status оставляет status у каждого товара
+func projectOrder(order Order, fields []string) (any, error) {
+	if len(fields) == 0 {
+		return order, nil
+	}
+
+	data, err := json.Marshal(order)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации заказа %v: %w", order.OrderUID, err)
+	}
+	// суммы остаются json.Number, чтобы не потерять формат 18.10
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var source map[string]any
+	err = decoder.Decode(&source)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации заказа %v: %w", order.OrderUID, err)
+	}
+
+	projected := make(map[string]any)
+	for _, field := range fields {
+		projectInto(projected, source, strings.Split(field, "."))
+	}
+	return projected, nil
+}
+
+func projectInto(dst, src map[string]any, path []string) {
+	value, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = value
+		return
+	}
+
+	switch nested := value.(type) {
+	case map[string]any:
+		child, ok := dst[path[0]].(map[string]any)
+		if !ok {
+			child = make(map[string]any)
+			dst[path[0]] = child
+		}
+		projectInto(child, nested, path[1:])
+	case []any:
+		children, ok := dst[path[0]].([]any)
+		if !ok {
+			children = make([]any, len(nested))
+			for i := range children {
+				children[i] = make(map[string]any)
+			}
+			dst[path[0]] = children
+		}
+		for i, item := range nested {
+			child, ok := children[i].(map[string]any)
+			if src, isMap := item.(map[string]any); ok && isMap {
+				projectInto(child, src, path[1:])
+			}
+		}
+	}
+}
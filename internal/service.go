@@ -2,68 +2,251 @@ package internal
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func GetOrderByID(db *sql.DB, orderUID string, cache map[string]Order) (Order, error) {
-	order, ok := cache[orderUID]
+func GetOrderByID(ctx context.Context, repo OrderRepository, orderUID string, cache Cache) (Order, error) {
+	logger := Logger(ctx).With(slog.String(LogKeyOrderUID, orderUID))
+
+	_, span := tracer.Start(ctx, "cache get")
+	order, ok := cache.Get(orderUID)
+	span.SetAttributes(attribute.Bool("cache.hit", ok))
+	span.End()
 	if ok {
 		return order, nil
 	} else {
-		log.Printf("Заказ с orderUID == %v в кеше не найден. ", orderUID)
+		logger.Debug("Заказ в кеше не найден")
 	}
 
-	order, err := getOrderByIdFromDB(context.Background(), db, orderUID)
+	dbCtx, span := tracer.Start(ctx, "GetOrderByID db")
+	order, err := repo.GetByID(dbCtx, orderUID)
+	endSpan(span, err)
 	if err != nil {
 		return order, fmt.Errorf("ошибка получения заказа из бд: %w. ", err)
 	}
-	cache[orderUID] = order
-	log.Printf("Заказ с orderUID == %v добавлен в кеш. ", orderUID)
+
+	// воркер мог сохранить более новую версию между чтением из бд и записью в кеш
+	_, span = tracer.Start(ctx, "cache set")
+	if cache.SetIfNewer(orderUID, order) {
+		logger.Debug("Заказ добавлен в кеш")
+	}
+	span.End()
 
 	return order, nil
 }
 
-func ProcessMessage(ctx context.Context, msg kafka.Message, db *sql.DB, cache map[string]Order) error {
-	var order Order
-	err := json.Unmarshal(msg.Value, &order)
+// GetOrdersBy возвращает заказы по вторичному ключу от новых к старым. Кеш отвечает сам, только если
+// в нем лежат все заказы значения; иначе заказы читаются из бд и кладутся в кеш вместе с отметкой полноты
+func GetOrdersBy(ctx context.Context, repo OrderRepository, cache Cache, index OrderIndex, value string) ([]Order, error) {
+	logger := Logger(ctx).With(slog.String(string(index), value))
+
+	_, span := tracer.Start(ctx, "cache lookup")
+	orders, complete := cache.Lookup(index, value)
+	span.SetAttributes(attribute.String("cache.index", string(index)), attribute.Bool("cache.hit", complete))
+	span.End()
+	if complete {
+		return orders, nil
+	}
+	logger.Debug("Заказы по ключу в кеше неполные")
+
+	dbCtx, span := tracer.Start(ctx, "GetOrdersBy db")
+	orders, err := repo.ListBy(dbCtx, index, value)
+	endSpan(span, err)
 	if err != nil {
-		return fmt.Errorf("ошибка десеарилизации сообщения: %w. ", err)
+		return nil, fmt.Errorf("ошибка получения заказов из бд: %w. ", err)
 	}
 
-	log.Printf("Процессинг сообщения заказа с id == %v. ", order.OrderUID)
+	_, span = tracer.Start(ctx, "cache set complete")
+	cache.SetComplete(index, value, orders)
+	span.End()
+
+	return orders, nil
+}
+
+func ProcessMessage(ctx context.Context, msg kafka.Message, repo OrderRepository, cache Cache, validator *Validator) error {
+	event, err := DecodeMessage(ctx, msg, validator)
+	if err != nil {
+		return err
+	}
+
+	return SaveOrders(ctx, repo, cache, []OrderEvent{event})
+}
 
-	ok, err := order.ValidateMessageData()
-	if !ok {
+// DecodeMessage десериализует и валидирует заказ, не сохраняя его
+func DecodeMessage(ctx context.Context, msg kafka.Message, validator *Validator) (OrderEvent, error) {
+	if validator.strictSchema() {
+		_, span := tracer.Start(ctx, "schema")
+		err := ValidateOrderSchema(msg.Value).Err()
+		endSpan(span, err)
 		if err != nil {
-			return fmt.Errorf("ошибка валидации заказа %v, %w", order.OrderUID, err)
+			return OrderEvent{}, &ProcessingError{Stage: StageSchema, Err: fmt.Errorf("сообщение не соответствует схеме заказа: %w", err)}
 		}
 	}
 
-	cache[order.OrderUID] = order
+	var order Order
+	_, span := tracer.Start(ctx, "unmarshal", trace.WithAttributes(attribute.Int("messaging.message.body.size", len(msg.Value))))
+	err := json.Unmarshal(msg.Value, &order)
+	endSpan(span, err)
+	if err != nil {
+		return OrderEvent{}, &ProcessingError{Stage: StageUnmarshal, Err: fmt.Errorf("ошибка десеарилизации сообщения: %w. ", err)}
+	}
+
+	// повторно отправленный заказ сохраняется, только если его событие не старше уже сохраненного
+	order.EventTime = msg.Time
+	if order.EventTime.IsZero() {
+		order.EventTime = time.Now()
+	}
+	order.EventTime = order.EventTime.UTC().Truncate(time.Microsecond)
+
+	logger := Logger(ctx)
+	if len(msg.Key) == 0 {
+		logger = logger.With(slog.String(LogKeyOrderUID, order.OrderUID))
+	}
+	logger.Debug("Процессинг сообщения заказа")
 
-	err = saveOrder(ctx, db, order)
+	_, span = tracer.Start(ctx, "ValidateMessageData")
+	result := validator.Validate(&order)
+	err = result.Err()
+	endSpan(span, err)
+	if len(result.Warnings) > 0 {
+		logger.Warn("Заказ не прошел проверки согласованности в режиме warn", slog.Any("warnings", result.Warnings))
+	}
 	if err != nil {
-		return fmt.Errorf("ошибка сохранения заказа с id == %v в бд: %v. ", order.OrderUID, err)
+		return OrderEvent{}, &ProcessingError{Stage: StageValidate, Err: fmt.Errorf("ошибка валидации заказа %v: %w", order.OrderUID, err)}
 	}
 
-	return nil
+	return OrderEvent{
+		Order:     order,
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+	}, nil
 }
 
-func FillCache(ctx context.Context, db *sql.DB, cache map[string]Order) error {
-	orders, err := getAlllOrders(ctx, db)
+// SaveOrders сохраняет пачку заказов вместе с записями истории одной транзакцией
+// и обновляет кеш только после ее коммита
+func SaveOrders(ctx context.Context, repo OrderRepository, cache Cache, events []OrderEvent) error {
+	ctx, span := tracer.Start(ctx, "SaveOrders", trace.WithAttributes(attribute.Int("orders.count", len(events))))
+	previous, err := previousVersions(ctx, repo, cache, events)
+	if err != nil {
+		endSpan(span, err)
+		return &ProcessingError{Stage: StageSave, Err: fmt.Errorf("ошибка получения сохраненных версий заказов: %w. ", err)}
+	}
+
+	writes := make([]OrderWrite, 0, len(events))
+	for _, event := range events {
+		var prev *Order
+		if order, ok := previous[event.Order.OrderUID]; ok {
+			prev = &order
+		}
+		entry := newHistoryEntry(event, prev)
+		writes = append(writes, OrderWrite{Order: event.Order, History: &entry})
+
+		// следующее событие этого же заказа в пачке сравнивается уже с этой версией
+		if prev == nil || !prev.EventTime.After(event.Order.EventTime) {
+			previous[event.Order.OrderUID] = event.Order
+		}
+	}
+
+	start := time.Now()
+	err = repo.Save(ctx, writes)
+	observeSave(start, len(writes), err)
 	if err != nil {
-		return fmt.Errorf("ошибка получения всех заказов: %v. ", err)
+		endSpan(span, err)
+		return &ProcessingError{Stage: StageSave, Err: fmt.Errorf("ошибка сохранения пачки из %v заказов в бд: %w. ", len(events), err)}
+	}
+
+	_, cacheSpan := tracer.Start(ctx, "cache update", trace.WithAttributes(attribute.Int("orders.count", len(events))))
+	// более старое событие не перезаписывает в кеше версию, которую бд уже не примет
+	for _, event := range events {
+		cache.SetIfNewer(event.Order.OrderUID, event.Order)
 	}
+	cacheSpan.End()
+	span.End()
+
+	return nil
+}
 
+// previousVersions ищет текущие версии заказов сначала в кеше, затем одним запросом в бд
+func previousVersions(ctx context.Context, repo OrderRepository, cache Cache, events []OrderEvent) (map[string]Order, error) {
+	previous := make(map[string]Order, len(events))
+	var missing []string
+	_, span := tracer.Start(ctx, "cache peek")
+	for _, event := range events {
+		uid := event.Order.OrderUID
+		if _, ok := previous[uid]; ok {
+			continue
+		}
+		if order, ok := cache.Peek(uid); ok {
+			previous[uid] = order
+			continue
+		}
+		missing = append(missing, uid)
+	}
+	span.SetAttributes(attribute.Int("cache.hits", len(previous)), attribute.Int("cache.misses", len(missing)))
+	span.End()
+
+	if len(missing) == 0 {
+		return previous, nil
+	}
+
+	orders, err := repo.List(ctx, ListOptions{OrderUIDs: missing})
+	if err != nil {
+		return nil, err
+	}
 	for _, order := range orders {
-		cache[order.OrderUID] = order
-		fmt.Printf("Занесено в кеш из БД: %v", order)
+		previous[order.OrderUID] = order
+	}
+
+	return previous, nil
+}
+
+func GetOrderHistory(ctx context.Context, repo OrderRepository, orderUID string) ([]OrderHistoryEntry, error) {
+	history, err := repo.History(ctx, orderUID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения истории заказа из бд: %w. ", err)
+	}
+
+	return history, nil
+}
+
+// warmupLogEvery - через сколько загруженных заказов прогрев пишет прогресс в лог
+const warmupLogEvery = 10000
+
+// FillCache загружает заказы в кеш страницами по opts.BatchSize, не держа в памяти всю выборку;
+// opts.Limit ограничивает прогрев N последними по date_created заказами, Limit <= 0 загружает все.
+// Прогрев идет параллельно с воркерами, поэтому заказ, уже обновленный воркером, не перезаписывается
+func FillCache(ctx context.Context, repo OrderRepository, cache Cache, opts ListOptions, progress *WarmupProgress) error {
+	if progress == nil {
+		progress = NewWarmupProgress()
+	}
+	logger := Logger(ctx)
+	logger.Info("Прогрев кеша начат", slog.Int("limit", opts.Limit), slog.Int("batch_size", opts.BatchSize))
+	progress.start()
+
+	err := repo.Stream(ctx, opts, func(order Order) error {
+		cache.SetIfNewer(order.OrderUID, order)
+		loaded := progress.loaded()
+		if loaded%warmupLogEvery == 0 {
+			logger.Info("Прогрев кеша продолжается", slog.Int64("orders", loaded))
+		}
+		return nil
+	})
+	progress.finish(err)
+	if err != nil {
+		return fmt.Errorf("ошибка прогрева кеша после %v заказов: %w. ", progress.Loaded(), err)
 	}
 
+	logger.Info("Кеш заполнен заказами из бд",
+		slog.Int64("orders", progress.Loaded()),
+		slog.Duration("duration", progress.Duration()),
+	)
 	return nil
 }
@@ -0,0 +1,265 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testOrder - заказ uid из события eventTime с датой создания dateCreated
+func testOrder(uid string, dateCreated, eventTime time.Time) Order {
+	order := expectedOrder(uid, expectedItem(1, "r1"))
+	order.DateCreated = dateCreated.Format(time.RFC3339)
+	order.EventTime = eventTime
+	return order
+}
+
+func testEvent(order Order, offset int64) OrderEvent {
+	return OrderEvent{Order: order, Topic: "orders", Partition: 0, Offset: offset}
+}
+
+func TestSaveOrders(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	cache := NewLRUCache(0, 0)
+
+	created := testOrder("a", assemblerDate, assemblerDate)
+	updated := created
+	updated.Delivery.Address = "Ploshad Mira 16"
+	updated.EventTime = assemblerDate.Add(time.Hour)
+	stale := created
+	stale.Delivery.Address = "Ploshad Mira 14"
+	stale.EventTime = assemblerDate.Add(-time.Hour)
+
+	err := SaveOrders(ctx, repo, cache, []OrderEvent{testEvent(created, 1), testEvent(updated, 2)})
+	if err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+	// событие старше сохраненного попадает в историю с applied=false, но не заменяет заказ ни в бд, ни в кеше
+	err = SaveOrders(ctx, repo, cache, []OrderEvent{testEvent(stale, 3)})
+	if err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+
+	stored, err := repo.GetByID(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if !reflect.DeepEqual(stored, updated) {
+		t.Errorf("в бд заказ %+v, want %+v", stored, updated)
+	}
+	cached, ok := cache.Peek("a")
+	if !ok || !reflect.DeepEqual(cached, updated) {
+		t.Errorf("в кеше заказ %+v (%v), want %+v", cached, ok, updated)
+	}
+
+	history, err := repo.History(ctx, "a")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	type entry struct {
+		offset  int64
+		kind    string
+		applied bool
+		changed []string
+	}
+	want := []entry{
+		{3, HistoryKindUpdated, false, []string{"delivery.address"}},
+		{1, HistoryKindCreated, true, []string{}},
+		{2, HistoryKindUpdated, true, []string{"delivery.address"}},
+	}
+	var got []entry
+	for _, h := range history {
+		got = append(got, entry{h.KafkaOffset, h.Kind, h.Applied, h.ChangedFields})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("история %+v, want %+v", got, want)
+	}
+}
+
+func TestSaveOrdersRejectsBatch(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	cache := NewLRUCache(0, 0)
+
+	valid := testOrder("a", assemblerDate, assemblerDate)
+	invalid := testOrder("b", assemblerDate, assemblerDate)
+	invalid.DateCreated = "вчера"
+
+	err := SaveOrders(ctx, repo, cache, []OrderEvent{testEvent(valid, 1), testEvent(invalid, 2)})
+	var procErr *ProcessingError
+	if !errors.As(err, &procErr) || procErr.Stage != StageSave {
+		t.Fatalf("SaveOrders() error = %v, want ProcessingError этапа %v", err, StageSave)
+	}
+	if _, err := repo.GetByID(ctx, "a"); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("заказ из отмененной пачки сохранен в бд: %v", err)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("в кеше %v заказов после ошибки сохранения, want 0", cache.Len())
+	}
+}
+
+func TestGetOrderByID(t *testing.T) {
+	ctx := context.Background()
+	inDB := testOrder("db", assemblerDate, assemblerDate)
+	inCache := testOrder("cached", assemblerDate, assemblerDate)
+
+	tests := []struct {
+		name      string
+		orderUID  string
+		want      Order
+		wantErr   error
+		wantCache bool
+	}{
+		{name: "попадание в кеш", orderUID: "cached", want: inCache, wantCache: true},
+		{name: "промах кеша", orderUID: "db", want: inDB, wantCache: true},
+		{name: "заказа нет", orderUID: "missing", wantErr: ErrOrderNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewMemoryRepository()
+			if err := repo.Save(ctx, []OrderWrite{{Order: inDB}}); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			// заказ есть только в кеше: ответ из кеша не обращается к бд
+			cache := NewLRUCache(0, 0)
+			cache.Set(inCache.OrderUID, inCache)
+
+			got, err := GetOrderByID(ctx, repo, tt.orderUID, cache)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("GetOrderByID() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("GetOrderByID() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetOrderByID() = %+v, want %+v", got, tt.want)
+			}
+
+			cached, ok := cache.Peek(tt.orderUID)
+			if ok != tt.wantCache || (ok && !reflect.DeepEqual(cached, tt.want)) {
+				t.Errorf("в кеше %+v (%v), want %+v (%v)", cached, ok, tt.want, tt.wantCache)
+			}
+		})
+	}
+}
+
+func TestFillCache(t *testing.T) {
+	ctx := context.Background()
+	oldest := testOrder("a", assemblerDate, assemblerDate)
+	middle := testOrder("b", assemblerDate.Add(time.Hour), assemblerDate)
+	newest := testOrder("c", assemblerDate.Add(2*time.Hour), assemblerDate)
+	// воркер уже положил в кеш более новую версию, чем в бд
+	newerMiddle := middle
+	newerMiddle.EventTime = assemblerDate.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		limit  int
+		cached []Order
+		want   []Order
+	}{
+		{name: "все заказы", want: []Order{oldest, middle, newest}},
+		{name: "последние по date_created", limit: 2, want: []Order{middle, newest}},
+		{name: "более новая версия в кеше", cached: []Order{newerMiddle}, want: []Order{oldest, newerMiddle, newest}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewMemoryRepository()
+			err := repo.Save(ctx, []OrderWrite{{Order: oldest}, {Order: middle}, {Order: newest}})
+			if err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			cache := NewLRUCache(0, 0)
+			for _, order := range tt.cached {
+				cache.Set(order.OrderUID, order)
+			}
+
+			progress := NewWarmupProgress()
+			err = FillCache(ctx, repo, cache, ListOptions{Limit: tt.limit, BatchSize: 1}, progress)
+			if err != nil {
+				t.Fatalf("FillCache() error = %v", err)
+			}
+
+			if !progress.Done() || progress.Err() != nil {
+				t.Errorf("прогрев не завершен: done=%v, err=%v", progress.Done(), progress.Err())
+			}
+			if cache.Len() != len(tt.want) {
+				t.Errorf("в кеше %v заказов, want %v", cache.Len(), len(tt.want))
+			}
+			for _, want := range tt.want {
+				got, ok := cache.Peek(want.OrderUID)
+				if !ok || !reflect.DeepEqual(got, want) {
+					t.Errorf("в кеше заказ %+v (%v), want %+v", got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFillCacheCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := NewMemoryRepository()
+	err := repo.Save(ctx, []OrderWrite{{Order: testOrder("a", assemblerDate, assemblerDate)}})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	cancel()
+
+	progress := NewWarmupProgress()
+	err = FillCache(ctx, repo, NewLRUCache(0, 0), ListOptions{}, progress)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("FillCache() error = %v, want %v", err, context.Canceled)
+	}
+	if !progress.Done() || !errors.Is(progress.Err(), context.Canceled) {
+		t.Errorf("прогрев: done=%v, err=%v", progress.Done(), progress.Err())
+	}
+}
+
+func TestGetOrderHistory(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	cache := NewLRUCache(0, 0)
+
+	created := testOrder("a", assemblerDate, assemblerDate)
+	shipped := created
+	shipped.Items = []Item{expectedItem(1, "r1")}
+	shipped.Items[0].Status = 300
+	shipped.EventTime = assemblerDate.Add(time.Hour)
+
+	err := SaveOrders(ctx, repo, cache, []OrderEvent{testEvent(created, 1)})
+	if err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+	// повторная доставка того же сообщения не добавляет запись в историю
+	err = SaveOrders(ctx, repo, cache, []OrderEvent{testEvent(shipped, 2), testEvent(shipped, 2)})
+	if err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+
+	history, err := GetOrderHistory(ctx, repo, "a")
+	if err != nil {
+		t.Fatalf("GetOrderHistory() error = %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("GetOrderHistory() вернул %v записей, want 2", len(history))
+	}
+	if history[0].Kind != HistoryKindCreated || history[1].Kind != HistoryKindUpdated {
+		t.Errorf("виды записей %v, %v, want %v, %v", history[0].Kind, history[1].Kind, HistoryKindCreated, HistoryKindUpdated)
+	}
+	from, to := 202, 300
+	wantTransitions := []ItemStatusTransition{{ChrtID: 1, Rid: "r1", From: &from, To: &to}}
+	if !reflect.DeepEqual(history[1].ItemStatusTransitions, wantTransitions) {
+		t.Errorf("переходы статусов %+v, want %+v", history[1].ItemStatusTransitions, wantTransitions)
+	}
+
+	empty, err := GetOrderHistory(ctx, repo, "missing")
+	if err != nil || len(empty) != 0 {
+		t.Errorf("GetOrderHistory() неизвестного заказа = %v, %v, want пустую историю", empty, err)
+	}
+}
@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer берется из глобального провайдера, поэтому spans начинают экспортироваться после InitTracing
+var tracer = otel.Tracer("l0")
+
+// InitTracing настраивает распространение контекста через заголовки W3C и, если трассировка включена,
+// экспорт spans по OTLP/HTTP. Возвращаемая функция дожидается отправки накопленных spans
+func InitTracing(ctx context.Context, cfg TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpoint(cfg.Endpoint),
+		otlptracehttp.WithInsecure(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания otlp экспортера: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка описания ресурса трассировки: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// HTTPTracing создает span на каждый http запрос, кроме проб и /metrics
+func HTTPTracing(service string) gin.HandlerFunc {
+	return otelgin.Middleware(service, otelgin.WithFilter(func(r *http.Request) bool {
+		switch r.URL.Path {
+		case "/metrics", "/healthz", "/readyz":
+			return false
+		}
+		return true
+	}))
+}
+
+// kafkaHeaderCarrier позволяет пропагатору читать и писать trace context в заголовки сообщения
+type kafkaHeaderCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c kafkaHeaderCarrier) Get(key string) string {
+	for _, h := range *c.headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c kafkaHeaderCarrier) Set(key, value string) {
+	for i, h := range *c.headers {
+		if h.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c kafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, h := range *c.headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// InjectTraceContext записывает текущий span ctx в заголовки сообщения
+func InjectTraceContext(ctx context.Context, msg *kafka.Message) {
+	otel.GetTextMapPropagator().Inject(ctx, kafkaHeaderCarrier{headers: &msg.Headers})
+}
+
+// ExtractTraceContext возвращает ctx с родительским span из заголовков сообщения
+func ExtractTraceContext(ctx context.Context, msg kafka.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, kafkaHeaderCarrier{headers: &msg.Headers})
+}
+
+func messageAttributes(msg kafka.Message) trace.SpanStartOption {
+	return trace.WithAttributes(
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("messaging.destination.name", msg.Topic),
+		attribute.Int("messaging.kafka.destination.partition", msg.Partition),
+		attribute.Int64("messaging.kafka.message.offset", msg.Offset),
+		attribute.String("messaging.kafka.message.key", string(msg.Key)),
+	)
+}
+
+// startMessageSpan начинает span обработки сообщения, продолжающий trace из его заголовков,
+// и добавляет trace_id в логгер ctx
+func startMessageSpan(ctx context.Context, msg kafka.Message) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ExtractTraceContext(ctx, msg), "orders process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		messageAttributes(msg),
+	)
+	return withTraceID(ctx), span
+}
+
+func withTraceID(ctx context.Context) context.Context {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsValid() {
+		return ctx
+	}
+	return WithLogger(ctx, Logger(ctx).With(slog.String(LogKeyTraceID, spanCtx.TraceID().String())))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// pgTracer создает span на каждый запрос к postgres, в том числе на каждый запрос из pgx.Batch
+type pgTracer struct{}
+
+type pgBatchTrace struct {
+	span trace.Span
+	last time.Time
+}
+
+type pgBatchTraceKey struct{}
+
+func (pgTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "postgres "+sqlOperation(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", data.SQL),
+		),
+	)
+	return ctx
+}
+
+func (pgTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	endSpan(trace.SpanFromContext(ctx), data.Err)
+}
+
+func (pgTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx, span := tracer.Start(ctx, "postgres batch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.Int("db.batch.size", data.Batch.Len()),
+		),
+	)
+	return context.WithValue(ctx, pgBatchTraceKey{}, &pgBatchTrace{span: span, last: time.Now()})
+}
+
+// TraceBatchQuery вызывается по мере чтения результатов пачки, поэтому span запроса
+// длится от результата предыдущего запроса до результата текущего
+func (pgTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	batch, ok := ctx.Value(pgBatchTraceKey{}).(*pgBatchTrace)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	_, span := tracer.Start(ctx, "postgres "+sqlOperation(data.SQL),
+		trace.WithTimestamp(batch.last),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", data.SQL),
+			attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()),
+		),
+	)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End(trace.WithTimestamp(now))
+	batch.last = now
+}
+
+func (pgTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	batch, ok := ctx.Value(pgBatchTraceKey{}).(*pgBatchTrace)
+	if !ok {
+		return
+	}
+	endSpan(batch.span, data.Err)
+}
+
+// sqlOperation возвращает имя операции и таблицы, например "INSERT orders", для имени span
+func sqlOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "query"
+	}
+
+	op := strings.ToUpper(fields[0])
+	var marker string
+	switch op {
+	case "INSERT":
+		marker = "INTO"
+	case "SELECT", "DELETE":
+		marker = "FROM"
+	case "UPDATE":
+		if len(fields) > 1 {
+			return op + " " + fields[1]
+		}
+		return op
+	default:
+		return op
+	}
+
+	for i := 1; i < len(fields)-1; i++ {
+		if strings.EqualFold(fields[i], marker) {
+			return op + " " + strings.Trim(fields[i+1], "(")
+		}
+	}
+	return op
+}
@@ -1,177 +1,272 @@
 package internal
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 )
 
-func validateMessageDataMainBody(order *Order) (bool, error) {
-	if order.OrderUID == "" {
-		return false, fmt.Errorf("order_uid пуст")
-	}
-	if order.TrackNumber == "" {
-		return false, fmt.Errorf("track_number пуст")
+// коды нарушений, по которым продюсер может разобрать отказ без чтения текста сообщения
+const (
+	ViolationRequired      = "required"
+	ViolationInvalidFormat = "invalid_format"
+	ViolationOutOfRange    = "out_of_range"
+	ViolationNotAllowed    = "not_allowed"
+	ViolationInvalidJSON   = "invalid_json"
+	ViolationInconsistent  = "inconsistent"
+)
+
+// Violation описывает одно нарушение; Path - путь к полю в сообщении, например items[2].price
+// Rule заполняется для нарушений правил согласованности (consistency.go)
+type Violation struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationResult содержит все нарушения заказа, а не только первое.
+// Warnings - нарушения правил в режиме warn, они не делают заказ некорректным
+type ValidationResult struct {
+	Violations []Violation `json:"violations"`
+	Warnings   []Violation `json:"warnings,omitempty"`
+}
+
+func (r *ValidationResult) add(path, code, format string, args ...any) {
+	r.Violations = append(r.Violations, Violation{
+		Path:    path,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *ValidationResult) required(path, value string) bool {
+	if value == "" {
+		r.add(path, ViolationRequired, "обязательное поле не заполнено")
+		return false
 	}
-	if order.Entry == "" {
-		return false, fmt.Errorf("entry пуст")
+	return true
+}
+
+// maxMoney отклоняет сумму, которая не поместится в колонку numeric(10,2)
+func (r *ValidationResult) maxMoney(path string, m Money) {
+	if m.Minor > maxMoneyMinor {
+		r.add(path, ViolationOutOfRange, "сумма %v больше допустимой %v", m, Money{Minor: maxMoneyMinor})
 	}
-	if order.Locale == "" {
-		return false, fmt.Errorf("locale пуст")
+}
+
+func (r ValidationResult) Valid() bool {
+	return len(r.Violations) == 0
+}
+
+// Err возвращает nil, если нарушений нет, иначе *ValidationError со всеми нарушениями
+func (r ValidationResult) Err() error {
+	if r.Valid() {
+		return nil
 	}
-	if order.CustomerID == "" {
-		return false, fmt.Errorf("customer_id пуст")
+	return &ValidationError{Violations: r.Violations}
+}
+
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		parts = append(parts, v.Path+": "+v.Message)
 	}
-	if order.DeliveryService == "" {
-		return false, fmt.Errorf("delivery_service пуст")
+	return strings.Join(parts, "; ")
+}
+
+// ValidateJSON разбирает и проверяет заказ так же, как при чтении из топика, но ничего не сохраняет
+func (v *Validator) ValidateJSON(data []byte) ValidationResult {
+	if v.strictSchema() {
+		result := ValidateOrderSchema(data)
+		if !result.Valid() {
+			return result
+		}
 	}
-	if order.Shardkey == "" {
-		return false, fmt.Errorf("shardkey пуст")
+
+	var order Order
+	err := json.Unmarshal(data, &order)
+	if err != nil {
+		var result ValidationResult
+		result.add("", ViolationInvalidJSON, "ошибка десеарилизации заказа: %v", err)
+		return result
 	}
-	if order.DateCreated == "" {
-		return false, fmt.Errorf("date_created пуст")
-	} else {
+
+	return v.Validate(&order)
+}
+
+func validateMessageDataMainBody(order *Order, r *ValidationResult) {
+	r.required("order_uid", order.OrderUID)
+	r.required("track_number", order.TrackNumber)
+	r.required("entry", order.Entry)
+	r.required("locale", order.Locale)
+	r.required("customer_id", order.CustomerID)
+	r.required("delivery_service", order.DeliveryService)
+	r.required("shardkey", order.Shardkey)
+	if r.required("date_created", order.DateCreated) {
 		err := validateDateRFC3339(order.DateCreated)
 		if err != nil {
-			return false, fmt.Errorf("date_created %w", err)
+			r.add("date_created", ViolationInvalidFormat, "%v", err)
 		}
 	}
-	if order.OofShard == "" {
-		return false, fmt.Errorf("oof_shard пуст")
-	}
-	return true, nil
+	r.required("oof_shard", order.OofShard)
 }
 
-func validateMessageDataDelivery(order *Order) (bool, error) {
-	if order.Delivery.Name == "" {
-		return false, fmt.Errorf("name пуст")
-	}
-	err := validatePhoneNumber(order.Delivery.Phone)
-	if err != nil {
-		return false, fmt.Errorf("некорректный формат phone: %w", err)
-	}
-	err = validateZipCode(order.Delivery.Zip)
-	if err != nil {
-		return false, fmt.Errorf("некорректный формат zip: %w", err)
-	}
-	if order.Delivery.City == "" {
-		return false, fmt.Errorf("city пуст")
-	}
-	if order.Delivery.Address == "" {
-		return false, fmt.Errorf("address пуст")
+// Validate проверяет поля доставки по встроенным справочникам; пути нарушений относительно доставки
+func (d Delivery) Validate() ValidationResult {
+	var result ValidationResult
+	d.validate("", builtinRules, &result)
+	return result
+}
+
+func (d Delivery) validate(prefix string, rules *referenceRules, r *ValidationResult) {
+	path := func(field string) string {
+		return joinSchemaPath(prefix, field)
 	}
-	if order.Delivery.Region == "" {
-		return false, fmt.Errorf("region пуст")
+
+	r.required(path("name"), d.Name)
+
+	// страна доставки в заказе не передается, поэтому определяется по коду страны телефона получателя
+	var country string
+	if r.required(path("phone"), d.Phone) {
+		var code string
+		var err error
+		country, code, err = rules.checkPhone(d.Phone)
+		if err != nil {
+			r.add(path("phone"), code, "некорректный формат phone: %v", err)
+		}
 	}
-	if order.Delivery.Email == "" {
-		return false, fmt.Errorf("email пуст")
+	if r.required(path("zip"), d.Zip) {
+		err := rules.checkPostalCode(d.Zip, country)
+		if err != nil {
+			r.add(path("zip"), ViolationInvalidFormat, "некорректный формат zip: %v", err)
+		}
 	}
+	r.required(path("city"), d.City)
+	r.required(path("address"), d.Address)
+	r.required(path("region"), d.Region)
+	r.required(path("email"), d.Email)
+}
 
-	return true, nil
+// Validate проверяет поля платежа по встроенным справочникам; пути нарушений относительно платежа
+func (p Payment) Validate() ValidationResult {
+	var result ValidationResult
+	p.validate("", builtinRules, &result)
+	return result
 }
 
-func validateMessageDataPayment(order *Order) (bool, error) {
-	if order.Payment.Transaction == "" {
-		return false, fmt.Errorf("transaction пуст")
+func (p Payment) validate(prefix string, rules *referenceRules, r *ValidationResult) {
+	path := func(field string) string {
+		return joinSchemaPath(prefix, field)
 	}
 
-	if order.Payment.Currency == "" {
-		return false, fmt.Errorf("transaction пуст")
-	} else {
-		if order.Payment.Currency != "USD" && order.Payment.Currency != "RUR" {
-			return false, fmt.Errorf("некорректная валюта, ожидается 'USD' или 'RUR'")
+	r.required(path("transaction"), p.Transaction)
+
+	if r.required(path("currency"), p.Currency) {
+		if !rules.currencies[p.Currency] {
+			r.add(path("currency"), ViolationNotAllowed, "некорректная валюта, ожидается одна из %v", allowedValues(rules.source.Currencies))
 		}
 	}
 
-	if order.Payment.Provider == "" {
-		return false, fmt.Errorf("provider пуст")
-	} else {
-		if order.Payment.Provider != "wbpay" && order.Payment.Provider != "other" {
-			return false, fmt.Errorf("некорректный провайдер, ожидается 'wbpay' или 'other'")
+	if r.required(path("provider"), p.Provider) {
+		if !rules.providers[p.Provider] {
+			r.add(path("provider"), ViolationNotAllowed, "некорректный провайдер, ожидается один из %v", allowedValues(rules.source.Providers))
 		}
 	}
 
-	if order.Payment.Amount <= 0 {
-		return false, fmt.Errorf("amount не может быть отрицательной")
+	if p.Amount.Minor <= 0 {
+		r.add(path("amount"), ViolationOutOfRange, "amount не может быть отрицательной или равной 0")
 	}
 
-	err := validateTimestamp(order.Payment.PaymentDt)
+	err := validateTimestamp(p.PaymentDt)
 	if err != nil {
-		return false, fmt.Errorf("ошибка валидации payment_dt: %w", err)
+		r.add(path("payment_dt"), ViolationOutOfRange, "ошибка валидации payment_dt: %v", err)
 	}
 
-	if order.Payment.Bank == "" {
-		return false, fmt.Errorf("bank пуст")
-	} else {
-		if order.Payment.Bank != "alpha" && order.Payment.Bank != "tbank" && order.Payment.Bank != "sber" {
-			return false, fmt.Errorf("некорректный банк, ожидается 'alpha', 'tbank' или 'sber'")
+	if r.required(path("bank"), p.Bank) {
+		if !rules.banks[p.Bank] {
+			r.add(path("bank"), ViolationNotAllowed, "некорректный банк, ожидается один из %v", allowedValues(rules.source.Banks))
 		}
 	}
 
-	if order.Payment.DeliveryCost < 0 {
-		return false, fmt.Errorf("delivery_cost не может быть отрицательной")
+	if p.DeliveryCost.Minor < 0 {
+		r.add(path("delivery_cost"), ViolationOutOfRange, "delivery_cost не может быть отрицательной")
 	}
 
-	if order.Payment.GoodsTotal <= 0 {
-		return false, fmt.Errorf("goods_total не может быть отрицательным или 0")
+	if p.GoodsTotal.Minor <= 0 {
+		r.add(path("goods_total"), ViolationOutOfRange, "goods_total не может быть отрицательным или 0")
 	}
 
-	if order.Payment.CustomFee < 0 {
-		return false, fmt.Errorf("custom_fee не может быть отрицательной")
+	if p.CustomFee.Minor < 0 {
+		r.add(path("custom_fee"), ViolationOutOfRange, "custom_fee не может быть отрицательной")
 	}
 
-	return true, nil
+	r.maxMoney(path("amount"), p.Amount)
+	r.maxMoney(path("delivery_cost"), p.DeliveryCost)
+	r.maxMoney(path("goods_total"), p.GoodsTotal)
+	r.maxMoney(path("custom_fee"), p.CustomFee)
 }
 
-func validateMessageDataItems(order *Order) (bool, error) {
+func validateMessageDataItems(order *Order, r *ValidationResult) {
 	if len(order.Items) == 0 {
-		return false, fmt.Errorf("количетсво товаров в заказе не может быть нулевым")
+		r.add("items", ViolationRequired, "количетсво товаров в заказе не может быть нулевым")
+		return
 	}
 
-	for _, item := range order.Items {
-		if item.ChrtID <= 0 {
-			return false, fmt.Errorf("chrt_id не может быть отрицательным или равным 0")
-		}
+	for i, item := range order.Items {
+		item.validate(fmt.Sprintf("items[%d]", i), r)
+	}
+}
 
-		if item.TrackNumber == "" {
-			return false, fmt.Errorf("track_number не может быть пустым")
-		}
+// Validate проверяет поля товара; пути нарушений относительно товара
+func (item Item) Validate() ValidationResult {
+	var result ValidationResult
+	item.validate("", &result)
+	return result
+}
 
-		if item.Price <= 0 {
-			return false, fmt.Errorf("price не может быть отрицательным или равным 0")
-		}
+func (item Item) validate(prefix string, r *ValidationResult) {
+	path := func(field string) string {
+		return joinSchemaPath(prefix, field)
+	}
 
-		if item.Rid == "" {
-			return false, fmt.Errorf("rid не может быть пустым")
-		}
+	if item.ChrtID <= 0 {
+		r.add(path("chrt_id"), ViolationOutOfRange, "chrt_id не может быть отрицательным или равным 0")
+	}
 
-		if item.Name == "" {
-			return false, fmt.Errorf("name не может быть пустым")
-		}
+	r.required(path("track_number"), item.TrackNumber)
 
-		if item.Sale < 0 && item.Sale > 100 {
-			return false, fmt.Errorf("sale должно быть в диапозоне от 0 до 100")
-		}
+	if item.Price.Minor <= 0 {
+		r.add(path("price"), ViolationOutOfRange, "price не может быть отрицательным или равным 0")
+	}
+	r.maxMoney(path("price"), item.Price)
 
-		if item.Size == "" {
-			return false, fmt.Errorf("size не может быть пустым")
-		}
+	r.required(path("rid"), item.Rid)
+	r.required(path("name"), item.Name)
 
-		if item.TotalPrice <= 0 {
-			return false, fmt.Errorf("total_price не может быть отрицательным или равным 0")
-		}
+	if item.Sale < 0 || item.Sale > 100 {
+		r.add(path("sale"), ViolationOutOfRange, "sale должно быть в диапозоне от 0 до 100")
+	}
 
-		if item.NmID <= 0 {
-			return false, fmt.Errorf("nm_id не может быть отрицательным или равным 0")
-		}
+	r.required(path("size"), item.Size)
 
-		if item.Brand == "" {
-			return false, fmt.Errorf("brand не может быть пустым")
-		}
+	if item.TotalPrice.Minor <= 0 {
+		r.add(path("total_price"), ViolationOutOfRange, "total_price не может быть отрицательным или равным 0")
+	}
+	r.maxMoney(path("total_price"), item.TotalPrice)
 
-		// не понятно, в каком диапозоне существуют статусы в системе, чтобы их валидировать
-		if item.Status < 0 {
-			return false, fmt.Errorf("status не может быть отрицательным")
-		}
+	if item.NmID <= 0 {
+		r.add(path("nm_id"), ViolationOutOfRange, "nm_id не может быть отрицательным или равным 0")
 	}
 
-	return true, nil
+	r.required(path("brand"), item.Brand)
+
+	// не понятно, в каком диапозоне существуют статусы в системе, чтобы их валидировать
+	if item.Status < 0 {
+		r.add(path("status"), ViolationOutOfRange, "status не может быть отрицательным")
+	}
 }
@@ -2,31 +2,331 @@ package internal
 
 import (
 	"context"
-	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func Worker(ctx context.Context, messages <-chan kafka.Message, db *sql.DB, cache map[string]Order, reader *kafka.Reader) {
+const (
+	DispatchByPartition = "partition"
+	DispatchByKey       = "key"
+)
+
+const workerQueueSize = 16
+
+// WorkerPool распределяет сообщения между Size воркерами так, чтобы сообщения одной партиции
+// (DispatchByPartition) или одного ключа (DispatchByKey) обрабатывались одним воркером по порядку
+type WorkerPool struct {
+	Size     int
+	Dispatch string
+	Repo     OrderRepository
+	Cache    Cache
+	Reader   *kafka.Reader
+	DLQ      *DeadLetterQueue
+	Retry    RetryPolicy
+
+	// Validator необязателен, без него проверяются только поля заказа
+	Validator *Validator
+
+	BatchSize   int
+	BatchWindow time.Duration
+
+	// Progress необязателен, используется проверкой готовности
+	Progress *WorkerProgress
+}
+
+// Run обрабатывает сообщения, пока канал messages не будет закрыт, и дожидается завершения всех воркеров.
+// ctx отменяется только если дообработка сообщений при остановке не уложилась в отведенное время
+func (p *WorkerPool) Run(ctx context.Context, messages <-chan kafka.Message) {
+	size := p.Size
+	if size < 1 {
+		size = 1
+	}
+
+	tracker := newOffsetTracker()
+	queues := make([]chan kafka.Message, size)
+
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan kafka.Message, workerQueueSize)
+		wg.Add(1)
+		go func(queue <-chan kafka.Message) {
+			defer wg.Done()
+			p.worker(ctx, queue, tracker)
+		}(queues[i])
+	}
+
+	for msg := range messages {
+		p.Progress.received()
+		if tracker.track(msg) {
+			Logger(withMessage(ctx, msg)).Warn("Партиция читается повторно с уже прочитанного offset, ожидаемые коммиты партиции сброшены")
+		}
+		queues[p.queueIndex(msg, size)] <- msg
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+}
+
+func (p *WorkerPool) queueIndex(msg kafka.Message, size int) int {
+	if p.Dispatch == DispatchByKey && len(msg.Key) > 0 {
+		h := fnv.New32a()
+		h.Write(msg.Key)
+		return int(h.Sum32() % uint32(size))
+	}
+	return msg.Partition % size
+}
+
+type pendingOrder struct {
+	msg   kafka.Message
+	event OrderEvent
+	span  trace.Span
+}
+
+// worker копит заказы до BatchSize или до истечения BatchWindow с момента первого заказа в пачке
+// и сохраняет их одной транзакцией; offset коммитится только после коммита транзакции
+func (p *WorkerPool) worker(ctx context.Context, queue <-chan kafka.Message, tracker *offsetTracker) {
+	batchSize := p.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	var batch []pendingOrder
+	timer := time.NewTimer(p.BatchWindow)
+	timer.Stop()
+	defer timer.Stop()
+
 	for {
-		msg, ok := <-messages
-		if !ok {
-			log.Printf("ошибка получения сообщения из канала. Topic: %v, offset: %v\n", msg.Topic, msg.Offset)
-			continue
+		select {
+		case msg, ok := <-queue:
+			if !ok {
+				p.flush(ctx, batch, tracker)
+				return
+			}
+
+			msgCtx, span := startMessageSpan(withMessage(ctx, msg), msg)
+			event, err := DecodeMessage(msgCtx, msg, p.Validator)
+			if err != nil {
+				if p.reject(msgCtx, msg, 1, err) == nil {
+					p.complete(ctx, tracker, msg)
+				}
+				span.End()
+				continue
+			}
+
+			if len(batch) == 0 {
+				timer.Reset(p.BatchWindow)
+			}
+			batch = append(batch, pendingOrder{msg: msg, event: event, span: span})
+			if len(batch) >= batchSize {
+				timer.Stop()
+				p.flush(ctx, batch, tracker)
+				batch = nil
+			}
+		case <-timer.C:
+			p.flush(ctx, batch, tracker)
+			batch = nil
 		}
+	}
+}
 
-		err := ProcessMessage(ctx, msg, db, cache)
-		if err != nil {
-			log.Printf("Ошибка обработки входящего сообщения: %v.\n", err)
-			continue
+func (p *WorkerPool) flush(ctx context.Context, batch []pendingOrder, tracker *offsetTracker) {
+	if len(batch) == 0 {
+		return
+	}
+
+	events := make([]OrderEvent, 0, len(batch))
+	links := make([]trace.Link, 0, len(batch))
+	for _, pending := range batch {
+		events = append(events, pending.event)
+		links = append(links, trace.Link{SpanContext: pending.span.SpanContext()})
+	}
+
+	// пачка собрана из сообщений разных trace, поэтому ее span связан с ними ссылками, а не вложен
+	saveCtx, saveSpan := tracer.Start(ctx, "orders save batch",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("orders.count", len(batch))),
+	)
+	saveCtx = withTraceID(saveCtx)
+
+	// временная ошибка (бд недоступна) повторяется для всей пачки: по одному заказы сохранились бы не лучше
+	_, err := p.retryTransient(ctx, Logger(saveCtx).With(slog.Int("orders", len(events))), func() error {
+		return SaveOrders(saveCtx, p.Repo, p.Cache, events)
+	})
+	endSpan(saveSpan, err)
+
+	if err == nil {
+		messagesProcessed.Add(float64(len(batch)))
+		msgs := make([]kafka.Message, 0, len(batch))
+		for _, pending := range batch {
+			msgs = append(msgs, pending.msg)
+			pending.span.End()
 		}
+		p.complete(ctx, tracker, msgs...)
+		return
+	}
+	if ctx.Err() != nil {
+		for _, pending := range batch {
+			endSpan(pending.span, ctx.Err())
+		}
+		return
+	}
+
+	// постоянная ошибка (нарушение ограничения, некорректная строка): сохраняем заказы по одному,
+	// чтобы отправить в dead-letter топик только проблемные
+	Logger(saveCtx).Warn("Ошибка сохранения пачки заказов, сохранение по одному",
+		slog.Int("orders", len(events)),
+		slog.Any(LogKeyError, err),
+	)
+	var handled []kafka.Message
+	for _, pending := range batch {
+		if p.handle(trace.ContextWithSpan(ctx, pending.span), pending.msg) {
+			handled = append(handled, pending.msg)
+		}
+		pending.span.End()
+	}
+	p.complete(ctx, tracker, handled...)
+}
 
-		err = reader.CommitMessages(ctx, msg)
-		if err != nil {
-			log.Printf("Коммит сообщения c offset=%v не удался с ошибкой %v\n", msg.Offset, err)
+// complete коммитит offset каждой партиции сообщений до последнего сообщения, перед которым
+// все сообщения партиции уже обработаны, одним коммитом на партицию.
+// Если обработка прервана остановкой сервиса, complete не вызывается, и сообщение будет прочитано повторно
+func (p *WorkerPool) complete(ctx context.Context, tracker *offsetTracker, msgs ...kafka.Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	for range msgs {
+		p.Progress.completed()
+	}
+
+	for _, commit := range tracker.complete(ctx, msgs, p.Reader.CommitMessages) {
+		if commit.err != nil {
+			Logger(withMessage(ctx, commit.msg)).Error("Коммит сообщения не удался", slog.Any(LogKeyError, commit.err))
 		} else {
-			log.Printf("Коммит сообщения c offset=%v удался\n", msg.Offset)
+			Logger(withMessage(ctx, commit.msg)).Debug("Коммит сообщения удался")
 		}
 	}
 }
+
+// retryTransient повторяет save, пока ошибка временная: первые p.Retry.MaxAttempts попыток с растущей
+// задержкой, дальше с задержкой последней из них, пока бд не станет доступна или сервис не начнет
+// остановку. Пачка и заказ по одному повторяются одинаково: временная ошибка не делает заказ
+// проблемным и не отправляет его в dead-letter топик. Пока save повторяется, воркер не читает
+// свою очередь, и чтение его партиций приостанавливается. Возвращает номер последней попытки
+func (p *WorkerPool) retryTransient(ctx context.Context, logger *slog.Logger, save func() error) (int, error) {
+	attempt := 1
+	err := save()
+	for err != nil && isTransientSaveError(err) {
+		delay := p.Retry.Backoff(attempt)
+		if p.Retry.ShouldRetry(err, attempt) {
+			logger.Warn("Временная ошибка сохранения, повтор",
+				slog.String(LogKeyStage, StageSave),
+				slog.Int("attempt", attempt),
+				slog.Int("max_attempts", p.Retry.MaxAttempts),
+				slog.Duration("delay", delay),
+				slog.Any(LogKeyError, err),
+			)
+		} else {
+			logger.Error("Бд недоступна дольше политики повторов, сохранение ожидает",
+				slog.String(LogKeyStage, StageSave),
+				slog.Int("attempt", attempt),
+				slog.Duration("delay", delay),
+				slog.Any(LogKeyError, err),
+			)
+		}
+		if !sleepContext(ctx, delay) {
+			break
+		}
+
+		if attempt < p.Retry.MaxAttempts {
+			attempt++
+		}
+		messageRetries.Inc()
+		err = save()
+	}
+	return attempt, err
+}
+
+// handle возвращает false, если сервис останавливается и обработку сообщения нужно повторить после перезапуска
+func (p *WorkerPool) handle(ctx context.Context, msg kafka.Message) bool {
+	ctx = withTraceID(withMessage(ctx, msg))
+
+	attempt, err := p.retryTransient(ctx, Logger(ctx), func() error {
+		return ProcessMessage(ctx, msg, p.Repo, p.Cache, p.Validator)
+	})
+	if err == nil {
+		messagesProcessed.Inc()
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	return p.reject(ctx, msg, attempt, err) == nil
+}
+
+// reject отправляет необработанное сообщение в dead-letter топик и повторяет отправку с задержкой
+// по p.Retry, пока она не удастся: воркер не читает свою очередь, а offset сообщения не коммитится.
+// Брошенное сообщение навсегда остановило бы коммиты партиции, а обработанные после него сообщения
+// копились бы в offsetTracker. Ошибка возвращается только при остановке сервиса, тогда сообщение
+// будет прочитано повторно после перезапуска.
+// ctx должен уже содержать логгер с полями сообщения (withMessage) и span его обработки
+func (p *WorkerPool) reject(ctx context.Context, msg kafka.Message, attempt int, err error) error {
+	stage := StageUnmarshal
+	var procErr *ProcessingError
+	if errors.As(err, &procErr) {
+		stage = procErr.Stage
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.SetAttributes(attribute.String("orders.reject_stage", stage))
+
+	logger := Logger(ctx).With(slog.String(LogKeyStage, stage))
+	attrs := []any{slog.Int("attempt", attempt), slog.Any(LogKeyError, err)}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		attrs = append(attrs, slog.Any("violations", validationErr.Violations))
+	}
+	logger.Error("Ошибка обработки входящего сообщения", attrs...)
+	messagesFailed.WithLabelValues(stage).Inc()
+
+	publishAttempt := 1
+	for {
+		publishErr := p.DLQ.Publish(ctx, msg, stage, attempt, err)
+		if publishErr == nil {
+			break
+		}
+		messagesDeadLetterFailed.WithLabelValues(stage).Inc()
+
+		delay := p.Retry.Backoff(publishAttempt)
+		logger.Warn("Ошибка отправки в dead-letter топик, повтор",
+			slog.Int("attempt", publishAttempt),
+			slog.Duration("delay", delay),
+			slog.Any(LogKeyError, publishErr),
+		)
+		if !sleepContext(ctx, delay) {
+			logger.Warn("Сообщение не отправлено в dead-letter топик до остановки сервиса, offset не коммитится")
+			return fmt.Errorf("сообщение не отправлено в dead-letter топик: %w", publishErr)
+		}
+		// задержка растет до RETRY_MAX_DELAY и дальше не увеличивается
+		if publishAttempt < p.Retry.MaxAttempts {
+			publishAttempt++
+		}
+	}
+
+	messagesDeadLettered.WithLabelValues(stage).Inc()
+	logger.Info("Сообщение отправлено в dead-letter топик")
+	return nil
+}
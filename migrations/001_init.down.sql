@@ -0,0 +1,5 @@
+DROP TABLE order_items;
+DROP TABLE items;
+DROP TABLE payment;
+DROP TABLE delivery;
+DROP TABLE orders;
@@ -0,0 +1,2 @@
+-- время события (timestamp сообщения kafka), по которому более старые версии заказа не перезаписывают более новые
+ALTER TABLE orders ADD COLUMN event_time timestamptz;
@@ -0,0 +1,17 @@
+-- журнал событий заказа: одна запись на каждое обработанное сообщение kafka
+CREATE TABLE order_history (
+    id bigserial PRIMARY KEY,
+    order_uid text NOT NULL, -- без внешнего ключа: история хранится и после удаления заказа
+    event_time timestamptz NOT NULL,
+    kafka_topic text NOT NULL,
+    kafka_partition integer NOT NULL,
+    kafka_offset bigint NOT NULL,
+    kind text NOT NULL, -- created, updated, unchanged
+    applied boolean NOT NULL, -- false, если событие старше сохраненной версии заказа
+    changed_fields jsonb NOT NULL DEFAULT '[]',
+    item_status_transitions jsonb NOT NULL DEFAULT '[]',
+    recorded_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (kafka_topic, kafka_partition, kafka_offset)
+);
+
+CREATE INDEX order_history_order_uid_idx ON order_history (order_uid, event_time);
@@ -0,0 +1 @@
+DROP TABLE reference_rules;
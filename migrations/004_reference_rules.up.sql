@@ -0,0 +1,24 @@
+-- справочники валидации для RULES_SOURCE=postgres, изменения применяются сервисом без перезапуска
+CREATE TABLE reference_rules (
+    kind text NOT NULL CHECK (kind IN ('currency', 'bank', 'provider', 'phone', 'postal_code')),
+    country text NOT NULL DEFAULT '', -- пустая строка: правило для любой страны
+    value text NOT NULL, -- код валюты ISO 4217, банк, провайдер, код страны телефона или регулярное выражение индекса
+    min_digits integer, -- только для phone: длина номера E.164 вместе с кодом страны
+    max_digits integer,
+    PRIMARY KEY (kind, country, value),
+    CHECK (kind <> 'phone' OR (min_digits IS NOT NULL AND max_digits IS NOT NULL))
+);
+
+-- правила, действовавшие до появления справочников
+INSERT INTO reference_rules (kind, value) VALUES
+    ('currency', 'USD'),
+    ('currency', 'RUR'),
+    ('bank', 'alpha'),
+    ('bank', 'tbank'),
+    ('bank', 'sber'),
+    ('provider', 'wbpay'),
+    ('provider', 'other'),
+    ('postal_code', '^\d{5,7}$');
+
+INSERT INTO reference_rules (kind, value, min_digits, max_digits) VALUES
+    ('phone', '', 11, 11);
@@ -0,0 +1,5 @@
+-- migrate:no-transaction
+-- постраничное чтение заказов от новых к старым по ключу (date_created, order_uid) при прогреве кеша.
+-- Индекс строится без блокировки записи в orders; DROP убирает невалидный индекс, оставшийся после сбоя
+DROP INDEX CONCURRENTLY IF EXISTS orders_date_created_idx;
+CREATE INDEX CONCURRENTLY orders_date_created_idx ON orders (date_created, order_uid);
@@ -0,0 +1,2 @@
+-- порядок товара в сообщении заказа; у строк, сохраненных раньше, порядок задают chrt_id и rid
+ALTER TABLE order_items ADD COLUMN position integer NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- migrate:no-transaction
+DROP INDEX CONCURRENTLY IF EXISTS order_items_chrt_id_idx;
+DROP INDEX CONCURRENTLY IF EXISTS items_nm_id_idx;
+DROP INDEX CONCURRENTLY IF EXISTS items_brand_idx;
+DROP INDEX CONCURRENTLY IF EXISTS delivery_phone_idx;
+DROP INDEX CONCURRENTLY IF EXISTS orders_track_number_idx;
+DROP INDEX CONCURRENTLY IF EXISTS orders_customer_id_idx;
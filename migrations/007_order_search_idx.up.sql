@@ -0,0 +1,18 @@
+-- migrate:no-transaction
+-- индексы для GET /orders. Выборочные фильтры идут с ключом сортировки, чтобы страница читалась
+-- по индексу без сортировки всех заказов клиента. bank, currency, delivery_service и status
+-- принимают несколько значений на все заказы, их индексы не отсекали бы строки, поэтому их нет.
+-- Индексы строятся без блокировки записи; DROP убирает невалидный индекс, оставшийся после сбоя
+DROP INDEX CONCURRENTLY IF EXISTS orders_customer_id_idx;
+CREATE INDEX CONCURRENTLY orders_customer_id_idx ON orders (customer_id, date_created, order_uid);
+DROP INDEX CONCURRENTLY IF EXISTS orders_track_number_idx;
+CREATE INDEX CONCURRENTLY orders_track_number_idx ON orders (track_number);
+DROP INDEX CONCURRENTLY IF EXISTS delivery_phone_idx;
+CREATE INDEX CONCURRENTLY delivery_phone_idx ON delivery (phone);
+DROP INDEX CONCURRENTLY IF EXISTS items_brand_idx;
+CREATE INDEX CONCURRENTLY items_brand_idx ON items (brand);
+DROP INDEX CONCURRENTLY IF EXISTS items_nm_id_idx;
+CREATE INDEX CONCURRENTLY items_nm_id_idx ON items (nm_id);
+-- товар -> заказы: первичный ключ order_items начинается с order_uid и для этого не подходит
+DROP INDEX CONCURRENTLY IF EXISTS order_items_chrt_id_idx;
+CREATE INDEX CONCURRENTLY order_items_chrt_id_idx ON order_items (chrt_id);
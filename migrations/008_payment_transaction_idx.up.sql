@@ -0,0 +1,4 @@
+-- migrate:no-transaction
+-- GET /orders/by-transaction; track_number и customer_id проиндексированы в 007
+DROP INDEX CONCURRENTLY IF EXISTS payment_transaction_idx;
+CREATE INDEX CONCURRENTLY payment_transaction_idx ON payment (transaction);
@@ -0,0 +1,8 @@
+package migrations
+
+import "embed"
+
+// FS содержит миграции схемы в формате <версия>_<название>.up.sql / .down.sql
+//
+//go:embed *.sql
+var FS embed.FS
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func main() {
@@ -16,22 +18,31 @@ func main() {
 	writer := &kafka.Writer{
 		Addr:     kafka.TCP("localhost:29092"),
 		Topic:    "orders",
-		Balancer: &kafka.LeastBytes{},
+		Balancer: &kafka.Hash{},
 	}
 
+	item := internal.NewItem(9934930, internal.NewMoney(453_00, "USD"), 30)
+	item.TrackNumber = "WBILMTESTTRACK"
+	item.Rid = "ab4219087a764ae0btest"
+	item.Name = "Mascaras"
+	item.Size = "0"
+	item.NmID = 2389212
+	item.Brand = "Vivienne Sabo"
+	item.Status = 202
+	items := []internal.Item{item}
+
+	payment := internal.NewPayment("b563feb7b2b84b6test", "USD", items,
+		internal.NewMoney(1500_00, "USD"), internal.NewMoney(0, "USD"))
+	payment.Provider = "wbpay"
+	payment.Bank = "alpha"
+	// payment.PaymentDt = 1637907727
+	payment.PaymentDt = time.Now().Unix()
+
 	orderExample := internal.Order{
 		OrderUID:    "b563feb7b2b84b6test",
 		TrackNumber: "WBILMTESTTRACK",
 		Entry:       "WBIL",
-		Delivery: struct {
-			Name    string `json:"name"`
-			Phone   string `json:"phone"`
-			Zip     string `json:"zip"`
-			City    string `json:"city"`
-			Address string `json:"address"`
-			Region  string `json:"region"`
-			Email   string `json:"email"`
-		}{
+		Delivery: internal.Delivery{
 			Name:    "Test Testov",
 			Phone:   "+98720000000",
 			Zip:     "2639809",
@@ -40,57 +51,8 @@ func main() {
 			Region:  "Kraiot",
 			Email:   "test@gmail.com",
 		},
-		Payment: struct {
-			Transaction  string `json:"transaction"`
-			RequestID    string `json:"request_id"`
-			Currency     string `json:"currency"`
-			Provider     string `json:"provider"`
-			Amount       int    `json:"amount"`
-			PaymentDt    int64  `json:"payment_dt"`
-			Bank         string `json:"bank"`
-			DeliveryCost int    `json:"delivery_cost"`
-			GoodsTotal   int    `json:"goods_total"`
-			CustomFee    int    `json:"custom_fee"`
-		}{
-			Transaction: "b563feb7b2b84b6test",
-			RequestID:   "",
-			Currency:    "USD",
-			Provider:    "wbpay",
-			Amount:      1817,
-			// PaymentDt:    1637907727,
-			PaymentDt:    time.Now().Unix(),
-			Bank:         "alpha",
-			DeliveryCost: 1500,
-			GoodsTotal:   317,
-			CustomFee:    0,
-		},
-		Items: []struct {
-			ChrtID      int    `json:"chrt_id"`
-			TrackNumber string `json:"track_number"`
-			Price       int    `json:"price"`
-			Rid         string `json:"rid"`
-			Name        string `json:"name"`
-			Sale        int    `json:"sale"`
-			Size        string `json:"size"`
-			TotalPrice  int    `json:"total_price"`
-			NmID        int    `json:"nm_id"`
-			Brand       string `json:"brand"`
-			Status      int    `json:"status"`
-		}{
-			{
-				ChrtID:      9934930,
-				TrackNumber: "WBILMTESTTRACK",
-				Price:       453,
-				Rid:         "ab4219087a764ae0btest",
-				Name:        "Mascaras",
-				Sale:        30,
-				Size:        "0",
-				TotalPrice:  317,
-				NmID:        2389212,
-				Brand:       "Vivienne Sabo",
-				Status:      202,
-			},
-		},
+		Payment:           payment,
+		Items:             items,
 		Locale:            "en",
 		InternalSignature: "",
 		CustomerID:        "test",
@@ -103,6 +65,19 @@ func main() {
 
 	ctx := context.Background()
 
+	// хардкод: otlp коллектор из docker-compose
+	shutdownTracing, err := internal.InitTracing(ctx, internal.TracingConfig{
+		Enabled:     true,
+		Endpoint:    "localhost:4318",
+		ServiceName: "l0-producer",
+		SampleRatio: 1,
+	})
+	if err != nil {
+		log.Fatalf("ошибка настройки трассировки: %v", err)
+	}
+	defer shutdownTracing(ctx)
+	tracer := otel.Tracer("l0-producer")
+
 	log.Println("эмулятор источника данных запущен...")
 
 	i := 1
@@ -120,14 +95,21 @@ func main() {
 		}
 		i++
 
-		err = writer.WriteMessages(ctx, kafka.Message{
+		msg := kafka.Message{
+			Key:   []byte(orderExample.OrderUID),
 			Value: value,
-		})
+		}
+		spanCtx, span := tracer.Start(ctx, "orders send", trace.WithSpanKind(trace.SpanKindProducer))
+		internal.InjectTraceContext(spanCtx, &msg)
+
+		err = writer.WriteMessages(spanCtx, msg)
 		if err != nil {
+			span.RecordError(err)
 			log.Printf("ошибка отправки в топик: %v", err)
 		} else {
 			log.Printf("сообщение отправлено: %s", orderExample.OrderUID)
 		}
+		span.End()
 
 		time.Sleep(1 * time.Second)
 	}